@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Metadata files in dir, keyed by path
+func readMetadataDir(fsys FS, dir string) (map[string]ShredMetadata, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]ShredMetadata{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), metadataSuffix) {
+			continue
+		}
+		metaPath := filepath.Join(dir, entry.Name())
+		metadata, err := loadMetadata(fsys, metaPath)
+		if err != nil {
+			continue // Not one of ours
+		}
+		found[metaPath] = metadata
+	}
+	return found, nil
+}
+
+// Drop paths that belong to shreds: metadata files, and the temporary
+// files of shreds in progress, which the next call resumes. Only files
+// that metadata next to the paths, or in metaDir if set, actually refers to
+// count as temporary, so user files that merely look like one are kept.
+func withoutArtifacts(fsys FS, paths []string, metaDir string) []string {
+	skip := map[string]bool{}
+	seen := map[string]bool{}
+	dirs := []string{}
+	if metaDir != "" {
+		dirs = append(dirs, metaDir)
+	}
+	for _, path := range paths {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		found, _ := readMetadataDir(fsys, dir)
+		for metaPath, metadata := range found {
+			skip[absPath(metaPath)] = true
+			if metadata.TempPath != "" {
+				skip[absPath(metadata.TempPath)] = true
+			}
+		}
+	}
+
+	var kept []string
+	for _, path := range paths {
+		if skip[absPath(path)] {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// The absolute form of path, or path itself if it can't be had
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// Remove metadata files in dir left behind by shreds whose temporary file
+// no longer exists, so they can never be resumed. Metadata of shreds that
+// can still be resumed is kept.
+func CleanOrphans(dir string) error {
+	found, err := readMetadataDir(osFS{}, dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for metaPath, metadata := range found {
+		if metadata.TempPath != "" {
+			if _, err := os.Lstat(metadata.TempPath); !os.IsNotExist(err) {
+				continue
+			}
+		}
+		err = os.Remove(metaPath)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
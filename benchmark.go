@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// Shred a temporary file of size bytes with passes random passes and report
+// what it took, to estimate how long wiping real data will take on this
+// machine: TotalBytes over Elapsed is the throughput. The file is created in
+// the default temporary directory, so it measures the disk that is on, and
+// filled before the timing starts.
+func ShredBenchmark(size int64, passes int64) (ShredReport, error) {
+	if size < 0 {
+		return ShredReport{}, errors.New("benchmark size must not be negative")
+	}
+
+	file, err := os.CreateTemp("", "fileshred-bench")
+	if err != nil {
+		return ShredReport{}, err
+	}
+	path := file.Name()
+
+	// Write it out first so the passes overwrite allocated blocks, as they
+	// would for a real file
+	writer := &passWriter{file: file, buf: make([]byte, DefaultBufferSize)}
+	_, err = writer.writePass(context.Background(), wholeFile(size), PatternZero.pass())
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		return ShredReport{}, err
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = passes
+	opts.CleanMetadataOnError = true
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		os.Remove(path)
+	}
+	return report, err
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceW = kernel32.NewProc("GetDiskFreeSpaceW")
+
+// Cluster size of the volume holding path
+func blockSize(path string) (int64, error) {
+	root, err := syscall.UTF16PtrFromString(filepath.VolumeName(path) + `\`)
+	if err != nil {
+		return 0, err
+	}
+
+	var sectorsPerCluster, bytesPerSector uint32
+	r, _, err := procGetDiskFreeSpaceW.Call(uintptr(unsafe.Pointer(root)),
+		uintptr(unsafe.Pointer(&sectorsPerCluster)), uintptr(unsafe.Pointer(&bytesPerSector)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return int64(sectorsPerCluster) * int64(bytesPerSector), nil
+}
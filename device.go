@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Safety switches for ShredDevice
+type DeviceOptions struct {
+	// Must be set, to confirm the whole device is to be destroyed
+	Confirm bool
+
+	// Wipe the device even if it or one of its partitions is mounted, used
+	// as swap or held by device-mapper or RAID
+	Force bool
+}
+
+// Overwrite an entire block device with random data. Refuses to run
+// unless opts.Confirm is set, and refuses devices in use unless opts.Force
+// is set too.
+func ShredDevice(devicePath string, passes int64, opts DeviceOptions) error {
+	if !opts.Confirm {
+		return errors.New("refusing to shred a device without confirmation")
+	}
+
+	info, err := os.Stat(devicePath)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("%s is not a device", devicePath)
+	}
+
+	reason, err := deviceInUse(devicePath)
+	if err != nil {
+		return err
+	}
+	if reason != "" && !opts.Force {
+		return fmt.Errorf("refusing to shred %s: %s", devicePath, reason)
+	}
+
+	// An exclusive open of a block device fails with EBUSY while the kernel
+	// has it mounted or held, whatever the checks above missed
+	flag := os.O_RDWR
+	if !opts.Force {
+		flag |= os.O_EXCL
+	}
+	device, err := os.OpenFile(devicePath, flag, 0)
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("refusing to shred %s: it is in use", devicePath)
+	}
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	// Stat reports a size of 0 for block devices, so ask the device
+	size, err := deviceSize(device)
+	if err != nil {
+		return err
+	}
+
+	return ShredFile(device, size, passes)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl request returning the size of a block device in bytes
+const blkGetSize64 = 0x80081272
+
+// Size in bytes of the block device
+func deviceSize(device *os.File) (int64, error) {
+	var size uint64
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int64(size), nil
+}
+
+// Where the kernel lists the block devices, with the holders of each
+var sysBlock = "/sys/class/block"
+
+// Why the device is in use: mounted, used as swap, or held by another
+// device such as an LVM volume, a LUKS mapping or a RAID array, through
+// itself or one of its partitions. Empty when it is free.
+func deviceInUse(devicePath string) (string, error) {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", err
+	}
+
+	listed, err := listsDevice("/proc/self/mounts", device)
+	if err != nil {
+		return "", err
+	}
+	if listed {
+		return "it is mounted", nil
+	}
+
+	listed, err = listsDevice("/proc/swaps", device)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if listed {
+		return "it is used as swap", nil
+	}
+
+	name := filepath.Base(device)
+	for _, pattern := range []string{"holders/*", name + "*/holders/*"} {
+		holders, err := filepath.Glob(filepath.Join(sysBlock, name, pattern))
+		if err != nil {
+			return "", err
+		}
+		if len(holders) > 0 {
+			return "it is held by " + filepath.Base(holders[0]), nil
+		}
+	}
+
+	return "", nil
+}
+
+// Whether the first column of a table such as /proc/self/mounts names the
+// device or one of its partitions
+func listsDevice(table string, device string) (bool, error) {
+	file, err := os.Open(table)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+
+		source, err := filepath.EvalSymlinks(fields[0])
+		if err != nil {
+			source = fields[0]
+		}
+		// A prefix match also catches partitions such as /dev/sda1
+		if strings.HasPrefix(source, device) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
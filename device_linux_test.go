@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListsDevice(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "mounts")
+	err := os.WriteFile(table, []byte("/dev/sdb2 /data ext4 rw 0 0\ntmpfs /tmp tmpfs rw 0 0\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for device, want := range map[string]bool{"/dev/sdb": true, "/dev/sdb2": true, "/dev/sdc": false} {
+		listed, err := listsDevice(table, device)
+		if err != nil || listed != want {
+			t.Errorf("listsDevice(%s) = %v, %v, want %v", device, listed, err, want)
+		}
+	}
+}
+
+// A device whose partition backs an LVM volume or a LUKS mapping is in use
+// even though only the mapping is mounted
+func TestDeviceInUseHolders(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "fakedisk")
+	writeTestFile(t, device, 1)
+
+	old := sysBlock
+	sysBlock = filepath.Join(dir, "block")
+	defer func() { sysBlock = old }()
+	err := os.MkdirAll(filepath.Join(sysBlock, "fakedisk", "fakedisk1", "holders"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reason, err := deviceInUse(device)
+	if err != nil || reason != "" {
+		t.Errorf("deviceInUse() = %q, %v for a free device", reason, err)
+	}
+
+	err = os.Mkdir(filepath.Join(sysBlock, "fakedisk", "fakedisk1", "holders", "dm-0"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reason, err = deviceInUse(device)
+	if err != nil || reason != "it is held by dm-0" {
+		t.Errorf("deviceInUse() = %q, %v, want it held by dm-0", reason, err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errDeviceUnsupported = errors.New("shredding devices is not supported on this platform")
+
+func deviceSize(device *os.File) (int64, error) {
+	return 0, errDeviceUnsupported
+}
+
+func deviceInUse(devicePath string) (string, error) {
+	return "", errDeviceUnsupported
+}
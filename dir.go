@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Shred every file below root, then remove the emptied directories bottom-up.
+// Symlinks are removed without being followed, so the walk never leaves
+// the tree. Metadata and temporary files of shreds in progress
+// are skipped. Named pipes, devices and sockets are left alone and reported
+// as ErrUnsupportedFileType. The filesystem root and the user's home
+// directory are refused outright.
+// Failures don't stop the walk; they are all returned joined together.
+func ShredDir(root string, passes int64) error {
+	opts := DefaultOptions()
+	opts.Passes = passes
+	return ShredDirWithOptions(root, opts)
+}
+
+// Shred every file below root as configured by opts, then remove the
+// emptied directories bottom-up
+func ShredDirWithOptions(root string, opts ShredOptions) error {
+	root, err := checkDir(root)
+	if err != nil {
+		return err
+	}
+
+	fsys := opts.fs()
+	var errs []error
+	var files []string
+	var dirs []string
+	var links []string
+
+	info, err := fsys.Lstat(root)
+	if err == nil {
+		err = walkDir(fsys, root, fs.FileInfoToDirEntry(info), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+
+			if d.IsDir() {
+				dirs = append(dirs, path)
+				return nil
+			}
+
+			if d.Type()&fs.ModeSymlink != 0 {
+				// Links are never followed out of the tree; only the link
+				// goes, so its directory can be removed
+				links = append(links, path)
+				return nil
+			} else if !d.Type().IsRegular() {
+				// Its directory can't be removed either, so say why
+				errs = append(errs, fmt.Errorf("%s: %w", path, ErrUnsupportedFileType))
+				return nil
+			}
+
+			files = append(files, path)
+			return nil
+		})
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	files = withoutArtifacts(fsys, files, opts.metadataDir())
+	_, shredErrs := shredAll(files, opts)
+	errs = append(errs, shredErrs...)
+
+	if opts.KeepFile {
+		return errors.Join(errs...)
+	}
+
+	if opts.DryRun {
+		for _, link := range links {
+			opts.logger().Info("would remove symlink", "path", link)
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			opts.logger().Info("would remove directory", "path", dirs[i])
+		}
+		opts.logger().Info("would shred files", "count", len(files))
+		return errors.Join(errs...)
+	}
+
+	for _, link := range links {
+		err = fsys.Remove(link)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Parents are visited first, so go backwards to remove children first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = fsys.Remove(dirs[i])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Shred every regular file matching the glob pattern, returning the paths
+// that were destroyed. Other matches, files that vanished in the meantime,
+// and metadata and temporary files of shreds in progress are skipped;
+// failures are all returned joined together.
+func ShredGlob(pattern string, passes int64) ([]string, error) {
+	opts := DefaultOptions()
+	opts.Passes = passes
+	return ShredGlobWithOptions(pattern, opts)
+}
+
+// Shred every regular file matching the glob pattern as configured by opts.
+// The pattern is matched against the operating system's filesystem, even
+// when opts.FS is set.
+func ShredGlobWithOptions(pattern string, opts ShredOptions) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := opts.fs()
+	var files []string
+	var errs []error
+	for _, path := range matches {
+		info, err := fsys.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, path)
+	}
+
+	shredded, shredErrs := shredAll(withoutArtifacts(fsys, files, opts.metadataDir()), opts)
+	return shredded, errors.Join(append(errs, shredErrs...)...)
+}
+
+// Shred the files with up to concurrency of them at once. Returns one error
+// per path, in the order of paths, nil for the files destroyed.
+func ShredMany(paths []string, passes int64, concurrency int) []error {
+	opts := DefaultOptions()
+	opts.Passes = passes
+	opts.Concurrency = concurrency
+	return shredEach(paths, opts)
+}
+
+// Shred the files using up to opts.Concurrency workers. Returns the files
+// destroyed and the failures, both in the order of paths.
+func shredAll(paths []string, opts ShredOptions) ([]string, []error) {
+	var shredded []string
+	var errs []error
+	for i, err := range shredEach(paths, opts) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", paths[i], err))
+			continue
+		}
+		shredded = append(shredded, paths[i])
+	}
+	return shredded, errs
+}
+
+// Shred the files using up to opts.Concurrency workers, returning the
+// error for each path
+func shredEach(paths []string, opts ShredOptions) []error {
+	workers := max(opts.Concurrency, 1)
+
+	// Each worker only writes the results for the paths it took
+	results := make([]error, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = ShredWithOptions(paths[i], opts)
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// Call fn for path, then, if it is a directory, for every entry below it
+// through fsys, parents before their children, like filepath.WalkDir.
+// Symlinks are not followed. A directory that can't be read is passed to
+// fn a second time with the error; an error returned by fn stops the walk.
+func walkDir(fsys FS, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	err := fn(path, d, nil)
+	if err != nil || !d.IsDir() {
+		return err
+	}
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return fn(path, d, err)
+	}
+	for _, entry := range entries {
+		err = walkDir(fsys, filepath.Join(path, entry.Name()), entry, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A directory with a stale metadata file, a resumable shred and a plain file
+func makeArtifactDir(t *testing.T) (dir, stale, active, temp, plain string) {
+	t.Helper()
+	dir = t.TempDir()
+
+	stale = filepath.Join(dir, "gone.txt"+metadataSuffix)
+	err := saveMetadata(osFS{}, rand.Reader, stale, ShredMetadata{Pass: 1, TempPath: filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temp = filepath.Join(dir, "Ab3dEf6hIj9k"+tempSuffix)
+	writeTestFile(t, temp, 128)
+	active = filepath.Join(dir, "active.txt"+metadataSuffix)
+	err = saveMetadata(osFS{}, rand.Reader, active, ShredMetadata{Pass: 1, TempPath: temp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain = filepath.Join(dir, "plain.txt")
+	writeTestFile(t, plain, 128)
+	return dir, stale, active, temp, plain
+}
+
+func TestCleanOrphans(t *testing.T) {
+	dir, stale, active, temp, plain := makeArtifactDir(t)
+
+	err := CleanOrphans(dir)
+	if err != nil {
+		t.Fatalf("CleanOrphans() error = %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale metadata was kept: %s", stale)
+	}
+	for _, path := range []string{active, temp, plain} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed", path)
+		}
+	}
+}
+
+// ShredGlob must leave metadata and in-progress files alone, but not user
+// files that only look like temporary ones
+func TestShredGlobSkipsArtifacts(t *testing.T) {
+	dir, stale, active, temp, plain := makeArtifactDir(t)
+	lookalike := filepath.Join(dir, "report202401"+tempSuffix)
+	writeTestFile(t, lookalike, 128)
+
+	shredded, err := ShredGlob(filepath.Join(dir, "*"), 1)
+	if err != nil {
+		t.Fatalf("ShredGlob() error = %v", err)
+	}
+	if len(shredded) != 2 || shredded[0] != plain || shredded[1] != lookalike {
+		t.Errorf("ShredGlob() shredded %v, want only %s and %s", shredded, plain, lookalike)
+	}
+	for _, path := range []string{stale, active, temp} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed", path)
+		}
+	}
+}
+
+// Every file is shredded and every directory removed, without following
+// a symlink out of the tree
+func TestShredDir(t *testing.T) {
+	outside := t.TempDir()
+	kept := filepath.Join(outside, "kept")
+	writeTestFile(t, kept, 128)
+
+	root := filepath.Join(t.TempDir(), "root")
+	for _, dir := range []string{"a/b/c", "d"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"top", "a/one", "a/b/two", "a/b/c/three", "d/four"} {
+		writeTestFile(t, filepath.Join(root, file), 4096)
+	}
+	err := os.Symlink(outside, filepath.Join(root, "a", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ShredDir(root, 1)
+	if err != nil {
+		t.Fatalf("ShredDir() error = %v", err)
+	}
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		t.Errorf("Directory still exists after shred: %s", root)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("File outside the tree was removed: %s", kept)
+	}
+}
+
+// Files in one directory are shredded in parallel, with errors matching
+// their paths
+func TestShredMany(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		writeTestFile(t, path, 4096)
+		paths = append(paths, path)
+	}
+	missing := 7
+	os.Remove(paths[missing])
+
+	errs := ShredMany(paths, 1, 4)
+	if len(errs) != len(paths) {
+		t.Fatalf("ShredMany() returned %d errors for %d paths", len(errs), len(paths))
+	}
+	for i, err := range errs {
+		if (err != nil) != (i == missing) {
+			t.Errorf("%s: error = %v", paths[i], err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d entries left in the directory", len(entries))
+	}
+}
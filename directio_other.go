@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Direct I/O needs O_DIRECT, which only Linux has
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.New("direct I/O is not supported on this platform")
+}
@@ -0,0 +1,18 @@
+package main
+
+import "errors"
+
+// Errors returned by the shred functions, wrapped with details about the
+// file. Check for them with errors.Is.
+var (
+	ErrFileLocked           = errors.New("file is locked by another process")
+	ErrSizeLimit            = errors.New("file size exceeds the allowed limit")
+	ErrAlreadyShredding     = errors.New("file is already being shredded")
+	ErrHardLinked           = errors.New("file has other hard links")
+	ErrUnsupportedFileType  = errors.New("not a regular file")
+	ErrTimeout              = errors.New("shred timed out")
+	ErrUnreliableFilesystem = errors.New("overwriting in place is unreliable on this filesystem")
+	ErrSymlink              = errors.New("file is a symbolic link")
+	ErrStaleMetadata        = errors.New("metadata doesn't match the file")
+	ErrNoSpace              = errors.New("no space left to overwrite the file")
+)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Space left free when wiping, so other processes don't run out entirely
+const freeSpaceMargin = 16 * 1024 * 1024
+
+// Measures the free space of the filesystem holding a directory; tests
+// replace it to wipe less than the whole disk
+var diskFree = freeSpace
+
+// Overwrite the free space of the filesystem holding dir with random data,
+// to destroy what is left of files deleted the normal way
+func WipeFreeSpace(dir string) error {
+	return WipeFreeSpacePasses(dir, 1)
+}
+
+// Like WipeFreeSpace, filling and releasing the free space passes times.
+// Passes must be at least 1.
+func WipeFreeSpacePasses(dir string, passes int64) error {
+	return wipeFreeSpace(osFS{}, dir, passes)
+}
+
+// Wipe the free space passes times, creating the filler files through fsys
+func wipeFreeSpace(fsys FS, dir string, passes int64) error {
+	if passes < 1 {
+		return fmt.Errorf("invalid number of passes %d", passes)
+	}
+	for i := int64(0); i < passes; i++ {
+		err := wipeFreeSpaceOnce(fsys, dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fill the free space minus the margin with a temporary file, then remove
+// it, whether the filling worked or not
+func wipeFreeSpaceOnce(fsys FS, dir string) error {
+	free, err := diskFree(dir)
+	if err != nil {
+		return err
+	}
+	if free <= freeSpaceMargin {
+		return nil
+	}
+
+	name, err := randomString(rand.Reader, tempNameLength)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "wipe"+name)
+	file, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer fsys.Remove(path)
+	defer file.Close()
+
+	// Running out of space first is expected, since other writers share it
+	writer := &passWriter{file: file, buf: make([]byte, DefaultBufferSize)}
+	_, err = writer.writePass(context.Background(), wholeFile(int64(free-freeSpaceMargin)), pass{})
+	if err != nil && !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+
+	return file.Sync()
+}
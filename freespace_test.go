@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// An FS whose files fail every write past limit bytes with err, like a full
+// or failing disk
+type limitFS struct {
+	osFS
+	limit int64
+	err   error
+}
+
+func (fsys limitFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return limitFile{file, fsys}, nil
+}
+
+type limitFile struct {
+	*os.File
+	fsys limitFS
+}
+
+func (f limitFile) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) <= f.fsys.limit {
+		return f.File.WriteAt(p, off)
+	}
+	n, err := f.File.WriteAt(p[:max(f.fsys.limit-off, 0)], off)
+	if err != nil {
+		return n, err
+	}
+	return n, &os.PathError{Op: "write", Path: f.Name(), Err: f.fsys.err}
+}
+
+// Pretend the filesystem has only a few MB to wipe
+func smallDisk(t *testing.T) {
+	t.Helper()
+	old := diskFree
+	diskFree = func(string) (uint64, error) { return freeSpaceMargin + 4*1024*1024, nil }
+	t.Cleanup(func() { diskFree = old })
+}
+
+func TestWipeFreeSpace(t *testing.T) {
+	smallDisk(t)
+	dir := t.TempDir()
+
+	err := WipeFreeSpacePasses(dir, 2)
+	if err != nil {
+		t.Fatalf("WipeFreeSpacePasses() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("filler files left behind: %v", entries)
+	}
+
+	// Running out of space before the estimate is part of the job
+	err = wipeFreeSpace(limitFS{limit: 1024 * 1024, err: syscall.ENOSPC}, dir, 1)
+	if err != nil {
+		t.Fatalf("wipeFreeSpace() on a full disk error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("filler files left behind on a full disk: %v", entries)
+	}
+
+	// Other write failures are returned, and the filler is still removed
+	err = wipeFreeSpace(limitFS{limit: 1024 * 1024, err: syscall.EIO}, dir, 1)
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("wipeFreeSpace() on a failing disk error = %v, want EIO", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("filler files left behind on a failing disk: %v", entries)
+	}
+}
+
+func TestWipeFreeSpacePassesInvalid(t *testing.T) {
+	smallDisk(t)
+	dir := t.TempDir()
+	for _, passes := range []int64{0, -1} {
+		err := WipeFreeSpacePasses(dir, passes)
+		if err == nil {
+			t.Errorf("WipeFreeSpacePasses(%d) succeeded", passes)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files created for an invalid pass count: %v", entries)
+	}
+}
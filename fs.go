@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+)
+
+// The filesystem operations a shred goes through, so tests can make them
+// fail or replace the filesystem altogether
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+	Chown(name string, uid, gid int) error
+}
+
+// An open file of an FS, as *os.File is. Files with an Fd() uintptr method
+// returning an operating system descriptor can also be locked against
+// other processes, searched for holes and trimmed; files without one are
+// only reachable through their FS, so there is no one else to lock out.
+// The Sys value of the FileInfos of files not of the operating system must
+// identify the file, such as a pointer to it, so a file moved or replaced
+// under a shred is noticed.
+type File interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// The operating system descriptor of file, if it has one
+func descriptor(file File) (uintptr, bool) {
+	f, ok := file.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, false
+	}
+	return f.Fd(), true
+}
+
+// Whether two FileInfos describe the same file, by os.SameFile or, for
+// files not of the operating system, by their Sys values
+func sameFile(a, b os.FileInfo) bool {
+	if os.SameFile(a, b) {
+		return true
+	}
+	sa, sb := a.Sys(), b.Sys()
+	return sa != nil && reflect.TypeOf(sa).Comparable() && sa == sb
+}
+
+// Whether the paths of fsys are those of the operating system, so system
+// calls that take a path, such as statfs and the extended attribute calls,
+// reach the same files. True for the default FS and types embedding it.
+func systemPaths(fsys FS) bool {
+	_, ok := fsys.(interface{ systemPaths() })
+	return ok
+}
+
+// The FS of the operating system, using the functions of package os
+type osFS struct{}
+
+func (osFS) systemPaths() {}
+
+func (osFS) Open(name string) (File, error) {
+	return openFile(name, os.O_RDONLY, 0)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openFile(name, flag, perm)
+}
+
+// Open a file of the operating system, returning a nil File rather than a
+// nil *os.File on failure
+func openFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
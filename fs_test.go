@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A file or directory of a memFS
+type memNode struct {
+	data []byte
+	mode os.FileMode
+	link string // Target, for symlinks
+}
+
+// An FS kept in memory, sharing nothing with the operating system's
+type memFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+func newMemFS(dirs ...string) *memFS {
+	fsys := &memFS{nodes: map[string]*memNode{}}
+	for _, dir := range dirs {
+		fsys.nodes[dir] = &memNode{mode: fs.ModeDir | 0o700}
+	}
+	return fsys
+}
+
+func (fsys *memFS) lookup(op, name string) (*memNode, error) {
+	node, ok := fsys.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return node, nil
+}
+
+func (fsys *memFS) Open(name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fsys *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	node, ok := fsys.nodes[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		if parent, ok := fsys.nodes[filepath.Dir(name)]; !ok || !parent.mode.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		node = &memNode{mode: perm}
+		fsys.nodes[name] = node
+	case flag&(os.O_WRONLY|os.O_RDWR) != 0 && node.mode&0o200 == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return &memFile{fsys: fsys, node: node, name: name}, nil
+}
+
+func (fsys *memFS) Rename(oldpath, newpath string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	node, err := fsys.lookup("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	delete(fsys.nodes, oldpath)
+	fsys.nodes[newpath] = node
+	return nil
+}
+
+func (fsys *memFS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if _, err := fsys.lookup("remove", name); err != nil {
+		return err
+	}
+	for other := range fsys.nodes {
+		if filepath.Dir(other) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	delete(fsys.nodes, name)
+	return nil
+}
+
+func (fsys *memFS) Stat(name string) (os.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	for i := 0; i < maxSymlinks; i++ {
+		node, err := fsys.lookup("stat", name)
+		if err != nil {
+			return nil, err
+		}
+		if node.mode&fs.ModeSymlink == 0 {
+			return memInfo{name: filepath.Base(name), node: node}, nil
+		}
+		name = filepath.Join(filepath.Dir(name), node.link)
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrInvalid}
+}
+
+func (fsys *memFS) Lstat(name string) (os.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	node, err := fsys.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{name: filepath.Base(name), node: node}, nil
+}
+
+func (fsys *memFS) ReadDir(name string) ([]os.DirEntry, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if _, err := fsys.lookup("readdir", name); err != nil {
+		return nil, err
+	}
+	var entries []os.DirEntry
+	for path, node := range fsys.nodes {
+		if filepath.Dir(path) == name && path != name {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(path), node: node}))
+		}
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (fsys *memFS) Readlink(name string) (string, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	node, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return node.link, nil
+}
+
+func (fsys *memFS) Chmod(name string, mode os.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	node, err := fsys.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode&^os.ModePerm | mode&os.ModePerm
+	return nil
+}
+
+func (fsys *memFS) Chown(name string, uid, gid int) error {
+	return nil
+}
+
+// Write a file into the memFS
+func (fsys *memFS) writeFile(name string, data []byte) {
+	fsys.nodes[name] = &memNode{data: slices.Clone(data), mode: 0o600}
+}
+
+// Paths in the memFS other than the directories, sorted
+func (fsys *memFS) files() []string {
+	var paths []string
+	for path, node := range fsys.nodes {
+		if !node.mode.IsDir() {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// The Sys value is the node, so it identifies the file
+type memInfo struct {
+	name string
+	node *memNode
+}
+
+func (info memInfo) Name() string       { return info.name }
+func (info memInfo) Size() int64        { return int64(len(info.node.data)) }
+func (info memInfo) Mode() os.FileMode  { return info.node.mode }
+func (info memInfo) ModTime() time.Time { return time.Time{} }
+func (info memInfo) IsDir() bool        { return info.node.mode.IsDir() }
+func (info memInfo) Sys() any           { return info.node }
+
+// An open file of a memFS
+type memFile struct {
+	fsys   *memFS
+	node   *memNode
+	name   string
+	offset int64
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	return copy(f.node.data[off:], p), nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	return memInfo{name: filepath.Base(f.name), node: f.node}, nil
+}
+
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+// A shred through an FS that isn't the operating system's works entirely
+// within it, from the passes to the metadata and the renames, and leaves
+// the real directory of the same name alone
+func TestShredMemFS(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	fsys := newMemFS(dir)
+	original := bytes.Repeat([]byte{0xAB}, 64*1024)
+	fsys.writeFile(path, original)
+
+	// Interrupt the shred after the first pass
+	var audit bytes.Buffer
+	opts := DefaultOptions()
+	opts.Passes = 2
+	opts.Fsync = true
+	opts.FS = fsys
+	opts.AuditWriter = &limitWriter{n: len(original)}
+	err := ShredWithOptions(path, opts)
+	if err == nil {
+		t.Fatal("ShredWithOptions() succeeded with a failing audit writer")
+	}
+	files := fsys.files()
+	if len(files) != 2 || slices.Contains(files, path) {
+		t.Fatalf("after the interrupted shred the files are %v, want the temporary file and the metadata", files)
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, metadataSuffix) && bytes.Contains(fsys.nodes[name].data, original[:16]) {
+			t.Errorf("%s still holds the original contents after a pass", name)
+		}
+	}
+
+	// Resume and finish
+	opts.AuditWriter = &audit
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("resumed ShredWithOptions() error = %v", err)
+	}
+	if audit.Len() != len(original) {
+		t.Errorf("resume wrote %d bytes, want one pass of %d", audit.Len(), len(original))
+	}
+	if files := fsys.files(); len(files) != 0 {
+		t.Errorf("files left in the memFS: %v", files)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the real directory was written to: %v", entries)
+	}
+}
+
+// Recover and ShredDirWithOptions go through the FS too
+func TestShredDirMemFS(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tree")
+	fsys := newMemFS(dir, root, filepath.Join(root, "sub"))
+	fsys.writeFile(filepath.Join(root, "a"), []byte("first"))
+	fsys.writeFile(filepath.Join(root, "sub", "b"), []byte("second"))
+	fsys.nodes[filepath.Join(root, "link")] = &memNode{mode: fs.ModeSymlink | 0o777, link: "a"}
+
+	path := filepath.Join(root, "a")
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.FS = fsys
+	opts.AuditWriter = failWriter{}
+	err := ShredWithOptions(path, opts)
+	if err == nil {
+		t.Fatal("ShredWithOptions() succeeded with a failing audit writer")
+	}
+	err = RecoverWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("RecoverWithOptions() error = %v", err)
+	}
+	if _, err := fsys.Stat(path); err != nil {
+		t.Fatalf("RecoverWithOptions() didn't bring the file back: %v", err)
+	}
+
+	opts.AuditWriter = nil
+	err = ShredDirWithOptions(root, opts)
+	if err != nil {
+		t.Fatalf("ShredDirWithOptions() error = %v", err)
+	}
+	if len(fsys.nodes) != 1 {
+		t.Errorf("left in the memFS: %v, want only %s", fsys.files(), dir)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the real directory was written to: %v", entries)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "syscall"
+
+// Filesystems where overwriting a file in place doesn't reliably reach the
+// blocks that held its data, keyed by their statfs magic number: network
+// filesystems write on a server that may keep snapshots and caches, and
+// copy-on-write filesystems write new blocks rather than reusing old ones
+var unreliableFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x00C36400: "ceph",
+	0x01021997: "9p",
+	0x9123683E: "btrfs",
+	0x2FC12FC1: "zfs",
+}
+
+// Name of the filesystem holding path if overwriting in place is unreliable
+// on it, or "" if it isn't known to be
+func unreliableFilesystem(path string) (string, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return "", err
+	}
+	return unreliableFilesystemType(int64(stat.Type)), nil
+}
+
+// Name of the unreliable filesystem with the statfs type, if any. The type
+// is int32 on some platforms, where the magic numbers above 0x7fffffff come
+// out negative, so only the low 32 bits are compared
+func unreliableFilesystemType(typ int64) string {
+	return unreliableFilesystems[uint32(typ)]
+}
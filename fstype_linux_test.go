@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestUnreliableFilesystemType(t *testing.T) {
+	for magic, name := range unreliableFilesystems {
+		// Statfs_t.Type is int64 on 64-bit platforms and int32 on 386 and arm
+		if got := unreliableFilesystemType(int64(magic)); got != name {
+			t.Errorf("unreliableFilesystemType(%#x) = %q, want %q", magic, got, name)
+		}
+		if got := unreliableFilesystemType(int64(int32(magic))); got != name {
+			t.Errorf("unreliableFilesystemType(int32 %#x) = %q, want %q", magic, got, name)
+		}
+	}
+	if got := unreliableFilesystemType(0xEF53); got != "" {
+		t.Errorf("unreliableFilesystemType(ext4) = %q, want \"\"", got)
+	}
+}
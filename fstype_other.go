@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// Filesystem types are only checked on Linux
+func unreliableFilesystem(path string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import "context"
+
+// The 27 fixed patterns of the Gutmann method, passes 5 to 31 in order
+var gutmannPatterns = [][]byte{
+	{0x55},
+	{0xAA},
+	{0x92, 0x49, 0x24},
+	{0x49, 0x24, 0x92},
+	{0x24, 0x92, 0x49},
+	{0x00},
+	{0x11},
+	{0x22},
+	{0x33},
+	{0x44},
+	{0x55},
+	{0x66},
+	{0x77},
+	{0x88},
+	{0x99},
+	{0xAA},
+	{0xBB},
+	{0xCC},
+	{0xDD},
+	{0xEE},
+	{0xFF},
+	{0x92, 0x49, 0x24},
+	{0x49, 0x24, 0x92},
+	{0x24, 0x92, 0x49},
+	{0x6D, 0xB6, 0xDB},
+	{0xB6, 0xDB, 0x6D},
+	{0xDB, 0x6D, 0xB6},
+}
+
+// Build the 35-pass Gutmann plan: 4 random, the fixed patterns, 4 random
+func gutmannPasses() []pass {
+	plan := randomPasses(4)
+	for _, pattern := range gutmannPatterns {
+		plan = append(plan, pass{Pattern: pattern})
+	}
+	return append(plan, randomPasses(4)...)
+}
+
+// Shred the file using the 35-pass Gutmann method. Progress is tracked in
+// the metadata file like Shred, so an interrupted run can be resumed.
+func ShredGutmann(path string) error {
+	_, err := shred(context.Background(), path, gutmannPasses(), DefaultOptions().withDefaults())
+	return err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// Whence values for lseek on Linux
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// Find the allocated regions of the file using SEEK_DATA and SEEK_HOLE
+func dataRegions(file File, size int64) ([]region, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fully allocated files have no holes to look for, and files that
+	// aren't the system's have no way to ask
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	seeker, canSeek := file.(io.Seeker)
+	if !ok || !canSeek || stat.Blocks*512 >= size {
+		return wholeFile(size), nil
+	}
+
+	var regions []region
+	for offset := int64(0); offset < size; {
+		data, err := seeker.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // No data past offset
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		hole, err := seeker.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		if data >= hole {
+			break
+		}
+
+		regions = append(regions, region{Offset: data, Length: hole - data})
+		offset = hole
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// A 4MB file with data only in its first and third megabytes
+func makeSparseFile(t *testing.T, path string) (size int64, data []region) {
+	t.Helper()
+	size = 4 * 1024 * 1024
+	data = []region{{Offset: 0, Length: 64 * 1024}, {Offset: 2 * 1024 * 1024, Length: 64 * 1024}}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = file.Truncate(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range data {
+		_, err = file.WriteAt(make([]byte, r.Length), r.Offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Sys().(*syscall.Stat_t).Blocks*512 >= size {
+		t.Skip("the filesystem doesn't keep files sparse")
+	}
+	return size, data
+}
+
+func TestDataRegions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse")
+	size, data := makeSparseFile(t, path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	regions, err := dataRegions(file, size)
+	if err != nil {
+		t.Fatalf("dataRegions() error = %v", err)
+	}
+	if !reflect.DeepEqual(regions, data) {
+		t.Errorf("dataRegions() = %v, want %v", regions, data)
+	}
+}
+
+// With SkipHoles only the data extents are written; without, the holes are
+// filled too
+func TestShredSkipHoles(t *testing.T) {
+	for _, skip := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "sparse")
+		size, data := makeSparseFile(t, path)
+		want := size
+		if skip {
+			want = data[0].Length + data[1].Length
+		}
+
+		opts := DefaultOptions()
+		opts.Passes = 2
+		opts.SkipHoles = skip
+		opts = opts.withDefaults()
+		report, err := shred(context.Background(), path, opts.plan(), opts)
+		if err != nil {
+			t.Fatalf("shred() error = %v", err)
+		}
+		if len(report.PassBytes) != 2 || report.PassBytes[0] != want || report.PassBytes[1] != want {
+			t.Errorf("SkipHoles=%v: PassBytes = %v, want two passes of %d bytes", skip, report.PassBytes, want)
+		}
+	}
+}
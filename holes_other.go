@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// Without SEEK_DATA support the whole file is treated as allocated
+func dataRegions(file File, size int64) ([]region, error) {
+	return wholeFile(size), nil
+}
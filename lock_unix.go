@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Take an exclusive lock on the file, waiting for it if necessary. Files
+// without a descriptor have nothing to lock.
+func lockFile(file File) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return nil
+	}
+	return syscall.Flock(int(fd), syscall.LOCK_EX)
+}
+
+// Take an exclusive lock on the file, failing if it is already held
+func tryLockFile(file File) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return nil
+	}
+	return syscall.Flock(int(fd), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// Release a lock taken with lockFile or tryLockFile
+func unlockFile(file File) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return nil
+	}
+	return syscall.Flock(int(fd), syscall.LOCK_UN)
+}
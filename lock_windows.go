@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// Flags for LockFileEx
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// Lock the whole file range. Files without a handle have nothing to lock.
+func lockFileEx(file File, flags uint32) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return nil
+	}
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(fd, uintptr(flags), 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Take an exclusive lock on the file, waiting for it if necessary
+func lockFile(file File) error {
+	return lockFileEx(file, lockfileExclusiveLock)
+}
+
+// Take an exclusive lock on the file, failing if it is already held
+func tryLockFile(file File) error {
+	return lockFileEx(file, lockfileExclusiveLock|lockfileFailImmediately)
+}
+
+// Release a lock taken with lockFile or tryLockFile
+func unlockFile(file File) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return nil
+	}
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(fd, 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
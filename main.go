@@ -1,124 +1,98 @@
-package main
-
-import (
-    "fmt"
-    "io/ioutil"
-    "os"
-    "sync"
-    "syscall"
-)
-
-func main() {
-    fmt.Println("Running tests...")
-    testCases := []struct {
-        name       string
-        createFile bool
-        fileSize   int64
-        expectErr  bool
-        fileType   string
-    }{
-        {"Small file", true, 128, false, "regular"},
-        {"Large file", true, 1024 * 1024, false, "regular"},
-        {"Empty file", true, 0, false, "regular"},
-        {"Non-existent file", false, 0, true, "regular"},
-        {"Read-only file", true, 128, true, "readonly"},
-        {"Symbolic link", true, 128, false, "symlink"},
-        {"Hard link", true, 128, false, "hardlink"},
-        {"Locked file", true, 128, true, "locked"},
-        {"Concurrent access", true, 128, false, "concurrent"},
-    }
-
-    for _, tt := range testCases {
-        fmt.Printf("Running test: %s\n", tt.name)
-        var path string
-        var targetPath string
-
-        if tt.createFile {
-            file, err := ioutil.TempFile("", "shredtest")
-            if err != nil {
-                fmt.Printf("Failed to create test file: %v\n", err)
-                continue
-            }
-            path = file.Name()
-            file.Truncate(tt.fileSize)
-            file.Close()
-
-            if tt.fileType == "readonly" {
-                err = os.Chmod(path, 0444)
-                if err != nil {
-                    fmt.Printf("Failed to set read-only permission: %v\n", err)
-                    continue
-                }
-            } else if tt.fileType == "symlink" {
-                targetPath = path + "_target"
-                err = os.Rename(path, targetPath)
-                if err != nil {
-                    fmt.Printf("Failed to rename file: %v\n", err)
-                    continue
-                }
-                err = os.Symlink(targetPath, path)
-                if err != nil {
-                    fmt.Printf("Failed to create symlink: %v\n", err)
-                    continue
-                }
-            } else if tt.fileType == "hardlink" {
-                targetPath = path + "_target"
-                err = os.Link(path, targetPath)
-                if err != nil {
-                    fmt.Printf("Failed to create hard link: %v\n", err)
-                    continue
-                }
-            } else if tt.fileType == "locked" {
-                file, err := os.OpenFile(path, os.O_RDWR, 0)
-                if err != nil {
-                    fmt.Printf("Failed to open file for locking: %v\n", err)
-                    continue
-                }
-                defer file.Close()
-                err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-                if err != nil {
-                    fmt.Printf("Failed to lock file: %v\n", err)
-                    continue
-                }
-            }
-        } else {
-            path = "nonexistentfile"
-        }
-
-        if tt.fileType == "concurrent" {
-            var wg sync.WaitGroup
-            wg.Add(2)
-            go func() {
-                defer wg.Done()
-                err := Shred(path, 3)
-                if (err != nil) != tt.expectErr {
-                    fmt.Printf("Shred() error = %v, expectErr %v\n", err, tt.expectErr)
-                }
-            }()
-            go func() {
-                defer wg.Done()
-                err := Shred(path, 3)
-                if (err != nil) != tt.expectErr {
-                    fmt.Printf("Shred() error = %v, expectErr %v\n", err, tt.expectErr)
-                }
-            }()
-            wg.Wait()
-        } else {
-            err := Shred(path, 3)
-            if (err != nil) != tt.expectErr {
-                fmt.Printf("Shred() error = %v, expectErr %v\n", err, tt.expectErr)
-            }
-        }
-
-        if tt.createFile && !tt.expectErr {
-            if _, err := os.Stat(path); !os.IsNotExist(err) {
-                fmt.Printf("File still exists after shred: %s\n", path)
-            }
-            if tt.fileType == "symlink" {
-                if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
-                    fmt.Printf("Target file of symlink still exists after shred: %s\n", targetPath)
-                }
-            }
-        }
-    }
-}
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: fileshred [-passes N] [-mode random|zero|dod|gutmann] [-spec rzo...] [-zero] [-recursive] [-force] [-remove=false] [-verbose] <path>...\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	passes := flag.Int64("passes", DefaultPasses, "number of overwrite passes")
+	mode := flag.String("mode", "random", "overwrite mode: random, zero, dod or gutmann")
+	flag.StringVar(mode, "pattern", "random", "old name of -mode")
+	spec := flag.String("spec", "", "passes as letters, r random, z zeros, o ones, e.g. rzr; overrides -passes and -mode")
+	zero := flag.Bool("zero", false, "add a final pass of zeros to hide the shredding")
+	recursive := flag.Bool("recursive", false, "shred directories and everything below them")
+	force := flag.Bool("force", false, "shred read-only and hard-linked files, and files on unreliable filesystems")
+	remove := flag.Bool("remove", true, "remove files once overwritten; false keeps them, zeroed")
+	verbose := flag.Bool("verbose", false, "print every path once it is shredded, and diagnostics")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = *passes
+	opts.Force = *force
+	opts.KeepFile = !*remove
+	opts.FinalZeroPass = *zero
+	if *verbose {
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	switch *mode {
+	case "random":
+	case "zero":
+		opts.Pattern = []byte{0x00}
+	case "dod":
+		opts.Overwrite = OverwriteDoD
+	case "gutmann":
+		opts.Overwrite = OverwriteGutmann
+	default:
+		fmt.Fprintf(os.Stderr, "fileshred: unknown mode %q\n", *mode)
+		os.Exit(2)
+	}
+
+	if *spec != "" {
+		_, err := ParsePassSpec(*spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fileshred: %v\n", err)
+			os.Exit(2)
+		}
+		opts.PassSpec = *spec
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		err := shredPath(path, opts, *recursive)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fileshred: %v\n", err)
+			failed = true
+			continue
+		}
+		if *verbose {
+			fmt.Printf("Shredded %s\n", path)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Shred a single command-line argument
+func shredPath(path string, opts ShredOptions, recursive bool) error {
+	// A missing path may be a shred interrupted after the rename, which
+	// ShredWithOptions resumes from the metadata, or fails on if there is none
+	info, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && info.IsDir() {
+		if !recursive {
+			return fmt.Errorf("%s is a directory, use -recursive", path)
+		}
+		return ShredDirWithOptions(path, opts)
+	}
+
+	return ShredWithOptions(path, opts)
+}
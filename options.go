@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Defaults used for zero-valued fields of ShredOptions, except
+// DefaultRenamePasses, which DefaultOptions sets since zero renames is a
+// valid choice
+const (
+	DefaultPasses       = 3
+	DefaultRenamePasses = 10
+	DefaultNameLength   = 12
+	DefaultBufferSize   = 64 * 1024
+)
+
+// Kind of data written by an overwrite pass
+type PassPattern int
+
+const (
+	PatternRandom PassPattern = iota // Fresh random data
+	PatternZero                      // All bits cleared, 0x00
+	PatternOne                       // All bits set, 0xFF
+)
+
+// Parse a pass specification, one letter per pass: r for random data, z for
+// zeros and o for ones, so "rzr" is random, zeros, random
+func ParsePassSpec(spec string) ([]PassPattern, error) {
+	if spec == "" {
+		return nil, errors.New("empty pass spec")
+	}
+	patterns := make([]PassPattern, 0, len(spec))
+	for i, c := range spec {
+		switch c {
+		case 'r':
+			patterns = append(patterns, PatternRandom)
+		case 'z':
+			patterns = append(patterns, PatternZero)
+		case 'o':
+			patterns = append(patterns, PatternOne)
+		default:
+			return nil, fmt.Errorf("pass spec %q: unknown pass %q at %d, want r, z or o", spec, c, i)
+		}
+	}
+	return patterns, nil
+}
+
+// The overwrite pass writing this pattern
+func (p PassPattern) pass() pass {
+	switch p {
+	case PatternZero:
+		return pass{Pattern: []byte{0x00}}
+	case PatternOne:
+		return pass{Pattern: []byte{0xFF}}
+	default:
+		return pass{}
+	}
+}
+
+// What the passes of a shred write, unless PassPatterns is set
+type OverwriteMode int
+
+const (
+	OverwriteRandom  OverwriteMode = iota // Random data, or Pattern if set
+	OverwriteZero                         // Zero bytes on every pass
+	OverwritePattern                      // Patterns, cycled over the passes
+	OverwriteGutmann                      // The 35 Gutmann passes, ignoring Passes
+	OverwriteDoD                          // The ShredDoD passes, ignoring Passes
+)
+
+// Shortest random name used when renaming at random lengths, unless
+// NameLength is shorter, keeping the chance of hitting an existing name low
+const minNameLength = 8
+
+// Options controlling how a file is shredded. The zero value is usable:
+// numeric fields fall back to their defaults and boolean fields are off.
+// DefaultOptions returns the options Shred uses, which also set
+// RenamePasses.
+type ShredOptions struct {
+	// Number of overwrite passes. Zero means DefaultPasses; negative values
+	// are an error.
+	Passes int64
+
+	// Skip renaming the file to random names before removing it, which
+	// scrubs the original name from the directory. On copy-on-write
+	// filesystems (btrfs, ZFS) and SSDs the old directory entries may
+	// survive anyway, so setting this mostly saves metadata churn there;
+	// combine it with a TRIM of the freed blocks on SSDs. The final
+	// directory entry is then the random temporary name, not the original.
+	// Renaming is on unless this is set, subject to RenamePasses.
+	NoRename bool
+
+	// Number of random renames before the file is removed, unless NoRename
+	// is set. Each rename rewrites the directory entry under a new name, so
+	// on filesystems that update entries in place the original name is
+	// overwritten; journaling and copy-on-write filesystems keep old
+	// entries around, and extra renames mostly add churn there, as they do
+	// on tmpfs where nothing reaches a disk. Zero skips the renames, leaving
+	// only the move to the temporary name; DefaultOptions sets
+	// DefaultRenamePasses. Negative values are an error.
+	RenamePasses int
+
+	// Longest random name used when renaming. Names vary in length between
+	// renames, from a few characters up to this. Zero means
+	// DefaultNameLength.
+	NameLength int
+
+	// Rename through names of every length from the original name's down
+	// to MinNameLength, one rename each, like shred -u. This overwrites the
+	// original entry and the slack after shorter names more thoroughly than
+	// random lengths, and never needs a name longer than the original, so
+	// it works within any name-length limit the directory has.
+	// RenamePasses and NameLength are ignored.
+	DescendingRenames bool
+
+	// Shortest name used when renaming. Zero means 8 for random lengths,
+	// or 1 with DescendingRenames.
+	MinNameLength int
+
+	// Size in bytes of the buffer used for each write. Zero means
+	// DefaultBufferSize.
+	BufferSize int
+
+	// Maximum write rate in bytes per second. Zero means unlimited.
+	BytesPerSecond int64
+
+	// Number of times to check again, LockRetryDelay apart, when the file is
+	// locked by another process, before failing with ErrFileLocked. Zero
+	// fails at once.
+	LockRetries    int
+	LockRetryDelay time.Duration
+
+	// Give up on the shred once this much time has passed, returning
+	// ErrTimeout. Like a cancelled context, this stops between writes and
+	// keeps the metadata file so the shred can be resumed. Zero means no
+	// limit.
+	Timeout time.Duration
+
+	// Make read-only files writable by their owner (mode 0600) so they can
+	// be overwritten. By default opening them fails with a permission error.
+	// The mode is put back if the shred fails or KeepFile is set. Only
+	// works for files the caller owns, or as root. Also implies
+	// ForceHardlinked, and shreds files on network and copy-on-write
+	// filesystems, which are otherwise refused with ErrUnreliableFilesystem
+	// since overwriting in place may not destroy the data there (Linux
+	// only).
+	Force bool
+
+	// Shred files that have more than one hard link, logging a warning.
+	// Their contents are destroyed for every link, but only the given name
+	// is removed. By default such files are refused with ErrHardLinked.
+	// Force implies this.
+	ForceHardlinked bool
+
+	// Deallocate the file's blocks with fallocate(FALLOC_FL_PUNCH_HOLE)
+	// before removing it, so an SSD can discard them. Overwriting on an
+	// SSD may leave the old data in blocks remapped by wear leveling; this
+	// at least tells the drive they are free. Linux only.
+	SSDTrim bool
+
+	// Largest file size in bytes that will be shredded; larger files are
+	// refused with ErrSizeLimit before anything is touched. Zero means no
+	// limit, which is what Shred uses. Set it to 1 << 30 to keep the 1GB
+	// guard older versions always applied.
+	MaxSize int64
+
+	// Only overwrite the allocated regions of sparse files, found with
+	// SEEK_DATA and SEEK_HOLE where supported. This keeps wiping a huge
+	// sparse image from filling the disk, but anything the filesystem
+	// reports as a hole is not overwritten. Off by default.
+	SkipHoles bool
+
+	// Before the file is truncated and removed, resize it to a random
+	// length between RandomSizeMin and RandomSizeMax bytes and overwrite it,
+	// so the last size on record isn't the original one. Growing the file
+	// allocates and writes extra blocks. Disabled when RandomSizeMax is zero.
+	RandomSizeMin int64
+	RandomSizeMax int64
+
+	// Generate random passes from a ChaCha8 keystream seeded from
+	// RandSource instead of reading RandSource directly. Much faster for
+	// large multi-pass jobs and still unpredictable, but the whole pass
+	// depends on a single 32-byte seed. Off by default.
+	FastRandom bool
+
+	// Source of all random data: passes, temporary and rename names, random
+	// sizes, the metadata salt and the names metadata is saved under before
+	// it is renamed into place. Nil means crypto/rand.Reader. Only set
+	// this to something deterministic in tests; a predictable source makes
+	// the random passes predictable too.
+	RandSource io.Reader
+
+	// Byte pattern repeated over the file on every pass. Nil writes fresh
+	// random data instead; an empty pattern is an error.
+	Pattern []byte
+
+	// File whose contents are used in place of Pattern, read once before
+	// the shred starts. It must be readable and not empty.
+	PatternFile string
+
+	// Add a pass of zeros after all the others, like shred --zero, so the
+	// file looks like ordinary empty space instead of high-entropy data
+	// that gives away the wipe. It costs one more full pass and adds no
+	// strength of its own; the passes before it do the destroying.
+	FinalZeroPass bool
+
+	// Selects what the passes write. The zero value, OverwriteRandom, keeps
+	// the behavior of Pattern.
+	Overwrite OverwriteMode
+
+	// Byte patterns for OverwritePattern, one per pass, e.g. 0x00, 0xFF,
+	// 0xAA. When there are fewer patterns than passes they are cycled; when
+	// there are none, Pattern is used for every pass.
+	Patterns [][]byte
+
+	// Patterns to apply, one pass each, in order. When set, Passes,
+	// Pattern, Overwrite and Patterns are ignored.
+	PassPatterns []PassPattern
+
+	// Passes as a string for ParsePassSpec, e.g. "rzr". When set it replaces
+	// PassPatterns; an invalid spec fails the shred before anything is
+	// touched.
+	PassSpec string
+
+	// Compute a SHA-256 of the original contents before the first pass and
+	// return it in ShredReport.ContentHash, for audit trails. This reads
+	// the whole file once more. Not done when resuming, since the contents
+	// are already gone.
+	HashContents bool
+
+	// Read back every write and fail at the first byte that differs from
+	// what was written. Random data is checked against the buffer it was
+	// written from, so this also catches media that accept writes but
+	// don't persist them.
+	Verify bool
+
+	// Open the file with O_DIRECT for the passes, so writes go to the device
+	// instead of the page cache and reads when verifying come back from
+	// it. Every pass then covers whole filesystem blocks. Linux only;
+	// elsewhere, or where the filesystem refuses O_DIRECT, a warning is
+	// logged and the passes go through the page cache as usual.
+	DirectIO bool
+
+	// Remove the file's extended attributes once it is overwritten, and
+	// return their names in ShredReport.Xattrs. They are stored apart from
+	// the contents, so the passes never touch them, and may hold labels or
+	// metadata as telling as the data. Attributes that can't be removed,
+	// such as security labels without the privilege, are logged as
+	// warnings. Linux only.
+	WipeXattrs bool
+
+	// Fsync the containing directory once the file is removed, so the
+	// renames and the unlink are durable. The file itself is synced after
+	// every pass unless SkipSync is set.
+	Fsync bool
+
+	// Don't sync the file after each pass. Faster, but the passes may then
+	// sit in the page cache and never reach the disk before the file is
+	// removed, so only use it for testing.
+	SkipSync bool
+
+	// Shred the file a symlink points to, then remove the link, which
+	// would dangle otherwise. By default symlinks are refused with
+	// ErrSymlink and both the link and its target are left untouched.
+	FollowSymlinks bool
+
+	// Called at the start of every pass and after every buffer written,
+	// with the 1-based pass number. May be nil.
+	Progress func(pass int64, bytesWritten, totalBytes int64)
+
+	// Receives a copy of every byte written to the file, pass after pass in
+	// the order written, for an audit record of the wipe. Random passes are
+	// copied too, so only send them somewhere as safe as the file. A failed
+	// write to it fails the shred. May be nil.
+	AuditWriter io.Writer
+
+	// Directory for the metadata file, which is named after a hash of the
+	// file's absolute path wherever it is kept. Empty keeps it next to the
+	// file.
+	MetadataDir string
+
+	// Directory the file is moved into for its temporary and random names,
+	// and the metadata file's directory unless MetadataDir is set, so
+	// nothing but the removal shows in the file's own directory. The file
+	// is renamed, never copied, so the passes still overwrite it where its
+	// data is; if WorkDir is on another filesystem the renames happen in the
+	// file's own directory instead, with a warning. Empty keeps everything
+	// next to the file.
+	WorkDir string
+
+	// Number of files shredded at once by ShredDirWithOptions and
+	// ShredGlobWithOptions. Zero or less means one at a time.
+	Concurrency int
+
+	// Log what would be done without writing, renaming or removing
+	// anything, and without creating a metadata file. The plan is logged at
+	// info level, so set Logger to see it.
+	DryRun bool
+
+	// Overwrite the file but keep it, like shred without -u: after the
+	// passes it is emptied and grown back to its original size, reading as
+	// zeros, under its original name. Nothing is renamed and no metadata
+	// is kept, so an interrupted shred starts over. ShredDirWithOptions
+	// then leaves the directories and symlinks in place too.
+	KeepFile bool
+
+	// Leave the metadata file in place once the shred completes, instead of
+	// removing it. Only useful for debugging: it tells that a shred took
+	// place, next to the file when MetadataDir is empty.
+	KeepMetadata bool
+
+	// When a shred fails after it started, move the file back to its
+	// original name and remove the metadata file, instead of keeping both
+	// for a resume. Contents already overwritten stay overwritten. A shred
+	// refused because another one holds the file leaves it alone.
+	CleanMetadataOnError bool
+
+	// Filesystem the shred goes through. Nil means the operating system's;
+	// tests set it to make operations fail, or to keep files in memory. On
+	// an FS other than the system's, what needs system calls is skipped:
+	// the filesystem and SSD checks, the slack of the last block, DirectIO
+	// and WipeXattrs. Unless its files have descriptors, SkipHoles then
+	// overwrites every byte and SSDTrim fails.
+	FS FS
+
+	// Receives diagnostics and dry run output. Nil discards them.
+	Logger *slog.Logger
+}
+
+// The options used by Shred and the other entry points that only take a
+// number of passes
+func DefaultOptions() ShredOptions {
+	return ShredOptions{
+		Passes:       DefaultPasses,
+		RenamePasses: DefaultRenamePasses,
+	}
+}
+
+// Fill in the defaults for zero-valued fields
+func (opts ShredOptions) withDefaults() ShredOptions {
+	if opts.Passes == 0 {
+		opts.Passes = DefaultPasses
+	}
+	if opts.NameLength == 0 {
+		opts.NameLength = DefaultNameLength
+	}
+	if opts.MinNameLength == 0 && !opts.DescendingRenames {
+		opts.MinNameLength = minNameLength
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	return opts
+}
+
+// Refuse options that would shred nothing or can't be carried out, before
+// the file is touched. A negative pass count would otherwise remove the
+// file without overwriting it.
+func (opts ShredOptions) validate() error {
+	if opts.Passes < 0 {
+		return fmt.Errorf("invalid number of passes %d", opts.Passes)
+	}
+	if opts.RenamePasses < 0 {
+		return fmt.Errorf("invalid number of rename passes %d", opts.RenamePasses)
+	}
+	// An empty pattern has nothing to repeat; nil is what asks for random data
+	if opts.Pattern != nil && len(opts.Pattern) == 0 {
+		return errors.New("empty Pattern")
+	}
+	for i, pattern := range opts.Patterns {
+		if len(pattern) == 0 {
+			return fmt.Errorf("empty pattern %d in Patterns", i)
+		}
+	}
+	return nil
+}
+
+// Parse PassSpec, if set, into PassPatterns
+func (opts ShredOptions) withPassSpec() (ShredOptions, error) {
+	if opts.PassSpec == "" {
+		return opts, nil
+	}
+	patterns, err := ParsePassSpec(opts.PassSpec)
+	if err != nil {
+		return opts, err
+	}
+	opts.PassPatterns = patterns
+	return opts, nil
+}
+
+// Read PatternFile, if set, into Pattern
+func (opts ShredOptions) withPatternFile() (ShredOptions, error) {
+	if opts.PatternFile == "" {
+		return opts, nil
+	}
+	pattern, err := os.ReadFile(opts.PatternFile)
+	if err != nil {
+		return opts, fmt.Errorf("pattern file: %w", err)
+	}
+	if len(pattern) == 0 {
+		return opts, fmt.Errorf("pattern file %s is empty", opts.PatternFile)
+	}
+	opts.Pattern = pattern
+	return opts, nil
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// Lengths of the successive rename targets for a file whose name is
+// originalLen long; zero means a random length
+func (opts ShredOptions) renameLengths(originalLen int) []int {
+	if opts.NoRename {
+		return nil
+	}
+	if !opts.DescendingRenames {
+		return make([]int, max(opts.RenamePasses, 0))
+	}
+
+	var lengths []int
+	for n := originalLen; n >= max(opts.MinNameLength, 1); n-- {
+		lengths = append(lengths, n)
+	}
+	return lengths
+}
+
+// Directory for the metadata file, empty for next to the file
+func (opts ShredOptions) metadataDir() string {
+	if opts.MetadataDir == "" {
+		return opts.WorkDir
+	}
+	return opts.MetadataDir
+}
+
+// Directory for the temporary and random names of the file at path
+func (opts ShredOptions) renameDir(path string) string {
+	if opts.WorkDir == "" {
+		return filepath.Dir(path)
+	}
+	return opts.WorkDir
+}
+
+// The logger to send diagnostics to, never nil
+func (opts ShredOptions) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return discardLogger
+	}
+	return opts.Logger
+}
+
+// The filesystem to use, never nil
+func (opts ShredOptions) fs() FS {
+	if opts.FS == nil {
+		return osFS{}
+	}
+	return opts.FS
+}
+
+// The source of random data, never nil
+func (opts ShredOptions) randSource() io.Reader {
+	if opts.RandSource == nil {
+		return rand.Reader
+	}
+	return opts.RandSource
+}
+
+// Build the overwrite plan described by the options
+func (opts ShredOptions) plan() []pass {
+	plan := opts.basePlan()
+	if opts.FinalZeroPass {
+		plan = append(plan, pass{Pattern: []byte{0x00}, Verify: opts.Verify})
+	}
+	return plan
+}
+
+// The plan before any final zero pass
+func (opts ShredOptions) basePlan() []pass {
+	if len(opts.PassPatterns) > 0 {
+		plan := make([]pass, len(opts.PassPatterns))
+		for i, p := range opts.PassPatterns {
+			plan[i] = p.pass()
+			plan[i].Verify = opts.Verify
+		}
+		return plan
+	}
+
+	if opts.Overwrite == OverwriteGutmann {
+		plan := gutmannPasses()
+		for i := range plan {
+			plan[i].Verify = opts.Verify
+		}
+		return plan
+	}
+
+	if opts.Overwrite == OverwriteDoD {
+		plan := append([]pass(nil), dodPasses...)
+		for i := range plan {
+			plan[i].Verify = plan[i].Verify || opts.Verify
+		}
+		return plan
+	}
+
+	plan := randomPasses(opts.Passes)
+	for i := range plan {
+		pattern := opts.Pattern
+		switch {
+		case opts.Overwrite == OverwriteZero:
+			pattern = []byte{0x00}
+		case opts.Overwrite == OverwritePattern && len(opts.Patterns) > 0:
+			pattern = opts.Patterns[i%len(opts.Patterns)]
+		}
+		plan[i] = pass{Pattern: pattern, Verify: opts.Verify}
+	}
+	return plan
+}
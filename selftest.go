@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Size of the buffers and the file used by SelfTest
+const selfTestSize = 64 * 1024
+
+// Check that the random source, file writes and locking work in this
+// environment, before trusting the tool with real data
+func SelfTest() error {
+	err := selfTestRandom()
+	if err != nil {
+		return fmt.Errorf("self-test: random source: %w", err)
+	}
+
+	file, err := os.CreateTemp("", "fileshred-selftest")
+	if err != nil {
+		return fmt.Errorf("self-test: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = selfTestWrite(file)
+	if err != nil {
+		return fmt.Errorf("self-test: write %s: %w", file.Name(), err)
+	}
+
+	err = selfTestLock(file)
+	if err != nil {
+		return fmt.Errorf("self-test: lock %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// Two reads must give different, non-zero data
+func selfTestRandom() error {
+	a := make([]byte, selfTestSize)
+	b := make([]byte, selfTestSize)
+	_, err := rand.Read(a)
+	if err != nil {
+		return err
+	}
+	_, err = rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(a, b) {
+		return errors.New("two reads returned the same data")
+	}
+	if bytes.Equal(a, make([]byte, selfTestSize)) {
+		return errors.New("read returned only zeros")
+	}
+	return nil
+}
+
+// A verified random pass and a pattern pass must both read back as written
+func selfTestWrite(file *os.File) error {
+	writer := &passWriter{file: file, buf: make([]byte, DefaultBufferSize)}
+	_, err := writer.writePass(context.Background(), wholeFile(selfTestSize), pass{Verify: true})
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.writePass(context.Background(), wholeFile(selfTestSize), PatternOne.pass())
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, selfTestSize)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, bytes.Repeat([]byte{0xFF}, selfTestSize)) {
+		return errors.New("data read back differs from what was written")
+	}
+	return nil
+}
+
+// Holding the lock must be visible to another open of the file, and
+// releasing it must be too
+func selfTestLock(file *os.File) error {
+	err := lockFile(file)
+	if err != nil {
+		return err
+	}
+	if !isFileLocked(osFS{}, file.Name()) {
+		unlockFile(file)
+		return errors.New("lock held but not detected")
+	}
+
+	err = unlockFile(file)
+	if err != nil {
+		return err
+	}
+	if isFileLocked(osFS{}, file.Name()) {
+		return errors.New("lock released but still detected")
+	}
+	return nil
+}
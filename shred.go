@@ -1,225 +1,1121 @@
-package main
-
-import (
-	"crypto/rand"
-	"encoding/json"
-	"fmt"
-	"os"
-	"syscall"
-)
-
-// Metadata to track progress
-type ShredMetadata struct {
-	Pass         int64
-	TempPath     string
-	OriginalPath string
-}
-
-// Save metadata to a file
-func saveMetadata(metadata ShredMetadata) error {
-	file, err := os.Create(metadata.OriginalPath + ".shredmeta")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(metadata)
-}
-
-// Load metadata from a file
-func loadMetadata(path string) (ShredMetadata, error) {
-	file, err := os.Open(path + ".shredmeta")
-	if err != nil {
-		return ShredMetadata{}, err
-	}
-	defer file.Close()
-
-	var metadata ShredMetadata
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&metadata)
-	return metadata, err
-}
-
-// Check if another process is trying to access the file
-func isFileLocked(path string) bool {
-	file, err := os.OpenFile(path, os.O_WRONLY, 0)
-	if err != nil {
-		return false
-	}
-	defer file.Close()
-
-	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-	if err != nil {
-		return true
-	}
-	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
-	return false
-}
-
-// Generate a random string of a given length
-func randomString(length int) (string, error) {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", err
-	}
-
-	for i := range b {
-		b[i] = charset[int(b[i])%len(charset)]
-	}
-
-	return string(b), nil
-}
-
-// Overwrite the entire device for SSDs
-func overwriteDevice(devicePath string) error {
-	device, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
-	if err != nil {
-		return err
-	}
-	defer device.Close()
-
-	info, err := device.Stat()
-	if err != nil {
-		return err
-	}
-
-	size := info.Size()
-	randomData := make([]byte, 4096) // Use 4KB blocks for writing
-
-	for written := int64(0); written < size; written += 4096 {
-		_, err = rand.Read(randomData)
-		if err != nil {
-			return err
-		}
-
-		_, err = device.WriteAt(randomData, written)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func Shred(path string, passes int64) error {
-	// File size verification
-	info, err := os.Stat(path)
-	if err != nil {
-		return err
-	}
-	if info.Size() > 1024*1024*1024 { // 1GB limit
-		return fmt.Errorf("file size exceeds the allowed limit")
-	}
-
-	// Load metadata if it exists
-	metadata, err := loadMetadata(path)
-	if err != nil {
-		metadata = ShredMetadata{Pass: 0, TempPath: "", OriginalPath: path}
-	}
-
-	// Rename the file to a temporary name if not already done
-	if metadata.TempPath == "" {
-		tempPath := path + ".tmp"
-		err = os.Rename(path, tempPath)
-		if err != nil {
-			return err
-		}
-		metadata.TempPath = tempPath
-		err = saveMetadata(metadata)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Check if another process is locking the temporary file
-	if isFileLocked(metadata.TempPath) {
-		fmt.Println("Temporary file is locked by another process: ", metadata.TempPath)
-		return fmt.Errorf("temporary file is locked by another process")
-	}
-
-	// Open the temporary file for writing
-	tempFile, err := os.OpenFile(metadata.TempPath, os.O_WRONLY, 0)
-	if err != nil {
-		return err
-	}
-	defer tempFile.Close()
-
-	// Acquire the lock on the temporary file
-	err = syscall.Flock(int(tempFile.Fd()), syscall.LOCK_EX)
-	if err != nil {
-		return err
-	}
-	defer syscall.Flock(int(tempFile.Fd()), syscall.LOCK_UN)
-
-	// Overwrite the file contents multiple times
-	randomData := make([]byte, info.Size())
-	for i := metadata.Pass; i < passes; i++ {
-		_, err = rand.Read(randomData)
-		if err != nil {
-			return err
-		}
-
-		_, err = tempFile.WriteAt(randomData, 0)
-		if err != nil {
-			return err
-		}
-
-		// Save progress to metadata file
-		metadata.Pass = i + 1
-		err = saveMetadata(metadata)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Rename the file to random names multiple times
-	for i := 0; i < 10; i++ { // Adjust the number of renames as needed
-		newName, err := randomString(12)
-		if err != nil {
-			return err
-		}
-
-		newPath := metadata.TempPath + "." + newName
-		err = os.Rename(metadata.TempPath, newPath)
-		if err != nil {
-			return err
-		}
-
-		metadata.TempPath = newPath
-		err = saveMetadata(metadata)
-		if err != nil {
-			return err
-		}
-	}
-
-	// Truncate the temporary file to 0 bytes
-	err = tempFile.Truncate(0)
-	if err != nil {
-		return err
-	}
-
-	// Remove the metadata file
-	err = os.Remove(metadata.OriginalPath + ".shredmeta")
-	if err != nil {
-		return err
-	}
-
-	// Remove the original file
-	err = os.Remove(metadata.TempPath)
-	if err != nil {
-		return err
-	}
-
-	// Overwrite the entire device for SSDs
-	// Note: Identify the device path where the file resides
-	//devicePath := "/dev/sdX" // Placeholder, should be identified dynamically
-	//err = overwriteDevice(devicePath)
-	//if err != nil {
-		//return err
-	//}
-
-	return nil
-}
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Suffixes of the files a shred leaves behind while it is in progress
+const (
+	metadataSuffix = ".shredmeta"
+	tempSuffix     = ".tmp"
+	tempNameLength = 12
+)
+
+// Metadata to track progress. The original path is never stored in clear,
+// only as a salted hash used to check the metadata belongs to the file.
+type ShredMetadata struct {
+	Pass     int64
+	TempPath string
+	Salt     string
+	PathHash string
+
+	// The file's permissions and owner before the shred, put back if it is
+	// recovered. Mode is zero when they weren't recorded.
+	Mode     os.FileMode
+	UID, GID int
+
+	// The file's inode and size when the metadata was last saved, checked
+	// before resuming so a different file under the temporary name is never
+	// taken for it. Zero when they weren't recorded.
+	Inode uint64
+	Size  int64
+}
+
+// Put back the permissions and owner recorded in the metadata
+func restoreAttributes(fsys FS, path string, metadata ShredMetadata) error {
+	if metadata.Mode == 0 {
+		return nil
+	}
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if uid, gid, ok := owner(info); ok && (uid != metadata.UID || gid != metadata.GID) {
+		err = fsys.Chown(path, metadata.UID, metadata.GID)
+		if err != nil {
+			return err
+		}
+	}
+	return fsys.Chmod(path, metadata.Mode)
+}
+
+// Start metadata for shredding path, with a fresh salt
+func newMetadata(random io.Reader, path string) (ShredMetadata, error) {
+	salt, err := randomString(random, 16)
+	if err != nil {
+		return ShredMetadata{}, err
+	}
+	return ShredMetadata{Salt: salt, PathHash: hashPath(salt, path)}, nil
+}
+
+// Salted hash of a path
+func hashPath(salt string, path string) string {
+	sum := sha256.Sum256([]byte(salt + path))
+	return hex.EncodeToString(sum[:])
+}
+
+// Where the metadata for path is kept: in dir, or next to the file when dir
+// is empty, under a name derived from a hash of the absolute path so the
+// original name doesn't stay on disk beside the renamed file
+func metadataPath(path string, dir string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		dir = filepath.Dir(abs)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+metadataSuffix), nil
+}
+
+// Save metadata to a file. It is written under another name, drawn from
+// random, and renamed into place, so a shred loading it concurrently never
+// sees half of it.
+func saveMetadata(fsys FS, random io.Reader, metaPath string, metadata ShredMetadata) error {
+	name, err := randomString(random, tempNameLength)
+	if err != nil {
+		return err
+	}
+	newPath := filepath.Join(filepath.Dir(metaPath), "."+name+metadataSuffix)
+	file, err := fsys.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer fsys.Remove(newPath)
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	err = encoder.Encode(metadata)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(newPath, metaPath)
+}
+
+// Load metadata from a file
+func loadMetadata(fsys FS, metaPath string) (ShredMetadata, error) {
+	file, err := fsys.Open(metaPath)
+	if err != nil {
+		return ShredMetadata{}, err
+	}
+	defer file.Close()
+
+	var metadata ShredMetadata
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&metadata)
+	return metadata, err
+}
+
+// Check if another process is trying to access the file
+func isFileLocked(fsys FS, path string) bool {
+	file, err := fsys.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	err = tryLockFile(file)
+	if err != nil {
+		return true
+	}
+	defer unlockFile(file)
+	return false
+}
+
+// Fsync a directory so changes to its entries reach the disk
+func syncDir(fsys FS, dir string) error {
+	d, err := fsys.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Generate a random string of a given length from the random source
+func randomString(random io.Reader, length int) (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// Bytes at or above the largest multiple of the charset size are
+	// rejected, so every character is equally likely
+	const limit = 256 - 256%len(charset)
+
+	b := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(b) < length {
+		_, err := io.ReadFull(random, buf)
+		if err != nil {
+			return "", err
+		}
+
+		for _, r := range buf {
+			if int(r) < limit && len(b) < length {
+				b = append(b, charset[int(r)%len(charset)])
+			}
+		}
+	}
+
+	return string(b), nil
+}
+
+// Attempts at finding an unused name of a fixed length before giving up on
+// that length; short lengths have few names to choose from
+const maxNameTries = 100
+
+// Pick an unused random name in dir for the next rename, of the given
+// length. A length of zero picks one between minLen and maxLen, varying
+// between renames and never matching avoidLen, the original name's length.
+// Returns "" if every name tried of a fixed length was taken.
+func renameTarget(fsys FS, random io.Reader, dir string, length, minLen, maxLen, avoidLen int) (string, error) {
+	minLen = min(minLen, maxLen)
+	for try := 0; length == 0 || try < maxNameTries; try++ {
+		n := length
+		if n == 0 {
+			size, err := randomSize(random, int64(minLen), int64(maxLen))
+			if err != nil {
+				return "", err
+			}
+			n = int(size)
+			if n == avoidLen {
+				if n > 1 {
+					n--
+				} else {
+					n++
+				}
+			}
+		}
+
+		name, err := randomString(random, n)
+		if err != nil {
+			return "", err
+		}
+
+		// Never clobber an existing entry
+		newPath := filepath.Join(dir, name)
+		if _, err := fsys.Lstat(newPath); os.IsNotExist(err) {
+			return newPath, nil
+		}
+	}
+	return "", nil
+}
+
+// Make path absolute and clean, so the names derived from it are
+// predictable, and refuse targets that are never meant to be shredded. A
+// path that doesn't exist passes, since an interrupted shred has already
+// moved the file away.
+func checkPath(fsys FS, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return "", fmt.Errorf("refusing to shred the root directory %s", abs)
+	}
+
+	info, err := fsys.Lstat(abs)
+	if err != nil {
+		return abs, nil
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%w: %s is a directory, use ShredDir", ErrUnsupportedFileType, path)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if exeInfo, err := os.Stat(exe); err == nil {
+			if target, err := fsys.Stat(abs); err == nil && os.SameFile(exeInfo, target) {
+				return "", fmt.Errorf("refusing to shred the running program %s", path)
+			}
+		}
+	}
+	return abs, nil
+}
+
+// Most symlinks followed in a chain before giving up, as the kernel does
+const maxSymlinks = 40
+
+// Follow path through symlinks to the file it finally names, relative
+// targets being relative to the link's directory
+func resolveLinks(fsys FS, path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := fsys.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := fsys.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("too many levels of symlinks at %s", path)
+}
+
+// Make root absolute and clean, and refuse directory trees that are never
+// meant to be shredded as a whole: the filesystem root and the user's home
+func checkDir(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return "", fmt.Errorf("refusing to shred the root directory %s", abs)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if homeAbs, err := filepath.Abs(home); err == nil && homeAbs == abs {
+			return "", fmt.Errorf("refusing to shred the home directory %s", abs)
+		}
+	}
+	return abs, nil
+}
+
+// Describe the type of a file that isn't a regular file
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "a directory"
+	case mode&os.ModeNamedPipe != 0:
+		return "a named pipe"
+	case mode&os.ModeSocket != 0:
+		return "a socket"
+	case mode&os.ModeCharDevice != 0:
+		return "a character device"
+	case mode&os.ModeDevice != 0:
+		return "a block device"
+	default:
+		return "not a regular file"
+	}
+}
+
+// Pick a random size in [min, max] using the random source
+func randomSize(random io.Reader, min, max int64) (int64, error) {
+	if min < 0 || max < min {
+		return 0, fmt.Errorf("invalid random size range %d-%d", min, max)
+	}
+	n, err := rand.Int(random, big.NewInt(max-min+1))
+	if err != nil {
+		return 0, err
+	}
+	return min + n.Int64(), nil
+}
+
+// Statistics about a shred, filled in as far as it got even on failure
+type ShredReport struct {
+	Size       int64   // Size of the file when the shred started
+	PassBytes  []int64 // Bytes written by each pass run
+	TotalBytes int64
+	Renames    int
+	Elapsed    time.Duration
+	Resumed    bool // Whether progress was picked up from a metadata file
+	// Passes of the plan done, including those of an earlier run when
+	// resuming, unlike PassBytes
+	PassesCompleted int64
+	// Last name the file had, under which it was removed
+	FinalPath string
+	// Hex SHA-256 of the original contents, when HashContents was set
+	ContentHash string
+	// The file as found before the shred touched it. Zero when resuming,
+	// since the file was already changed by then.
+	OriginalInfo FileRecord
+	// Names of the extended attributes found, when WipeXattrs was set
+	Xattrs []string
+}
+
+// What a file looked like, for audit records
+type FileRecord struct {
+	Name     string // Base name
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	UID, GID int // Zero where owners aren't numeric, as on Windows
+}
+
+// Shred the file: overwrite it passes times with random data, rename it and
+// remove it. The file is first moved to a random temporary name recorded in
+// path's metadata file, next to it. If the shred fails or is interrupted
+// after that, the file stays under the temporary name with the metadata
+// pointing at it, and nothing else is left behind: calling Shred again with
+// the same path resumes at the first pass not completed, Recover moves the
+// file back under path, and PurgeMetadata forgets the shred.
+func Shred(path string, passes int64) error {
+	return ShredContext(context.Background(), path, passes)
+}
+
+// Shred the file, stopping between writes once ctx is cancelled. The
+// metadata file is kept on cancellation so the shred can be resumed.
+func ShredContext(ctx context.Context, path string, passes int64) error {
+	opts := DefaultOptions()
+	opts.Passes = passes
+	opts = opts.withDefaults()
+	_, err := shred(ctx, path, opts.plan(), opts)
+	return err
+}
+
+// Shred the file and report what was done
+func ShredWithReport(path string, passes int64) (ShredReport, error) {
+	opts := DefaultOptions()
+	opts.Passes = passes
+	opts = opts.withDefaults()
+	return shred(context.Background(), path, opts.plan(), opts)
+}
+
+// Shred the file as configured by opts
+func ShredWithOptions(path string, opts ShredOptions) error {
+	opts, err := opts.withPatternFile()
+	if err != nil {
+		return err
+	}
+	opts, err = opts.withPassSpec()
+	if err != nil {
+		return err
+	}
+	opts = opts.withDefaults()
+	_, err = shred(context.Background(), path, opts.plan(), opts)
+	return err
+}
+
+// Shred the file using the DoD 5220.22-M sequence: zeros, ones, then
+// random data which is read back and verified
+func ShredDoD(path string) error {
+	_, err := shred(context.Background(), path, dodPasses, DefaultOptions().withDefaults())
+	return err
+}
+
+var dodPasses = []pass{
+	{Pattern: []byte{0x00}},
+	{Pattern: []byte{0xFF}},
+	{Verify: true},
+}
+
+// Overwrite the first size bytes of an open file in place with random
+// passes, syncing after each. The file must be open for reading and
+// writing; opening, locking, closing and removing it are left to the
+// caller, and no metadata is kept, so an interrupted call starts over.
+// Passing the size lets callers wipe devices, whose Stat size is 0.
+func ShredFile(f *os.File, size int64, passes int64) error {
+	opts := ShredOptions{Passes: passes}.withDefaults()
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+	writer, err := newPassWriter(f, opts)
+	if err != nil {
+		return err
+	}
+	var report ShredReport
+	return runPasses(context.Background(), writer, wholeFile(size), opts.plan(), 0, opts, &report, nil)
+}
+
+// Overwrite only the bytes in [offset, offset+length) of the file with
+// passes random passes, leaving the rest intact and the file in place.
+// Useful for destroying a header or a key embedded in a file worth keeping.
+// The range must lie within the file.
+func ShredRegion(path string, offset, length int64, passes int64) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = tryLockFile(file)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFileLocked, path)
+	}
+	defer unlockFile(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is %s", ErrUnsupportedFileType, path, fileType(info.Mode()))
+	}
+	if offset < 0 || length < 0 || offset > info.Size() || length > info.Size()-offset {
+		return fmt.Errorf("region %d+%d is outside %s, which is %d bytes", offset, length, path, info.Size())
+	}
+
+	opts := ShredOptions{Passes: passes}.withDefaults()
+	err = opts.validate()
+	if err != nil {
+		return err
+	}
+	writer, err := newPassWriter(file, opts)
+	if err != nil {
+		return err
+	}
+	var report ShredReport
+	regions := []region{{Offset: offset, Length: length}}
+	return runPasses(context.Background(), writer, regions, opts.plan(), 0, opts, &report, nil)
+}
+
+// Overwrite the file with each pass of the plan, then rename and remove it.
+// Once the first pass starts the original contents are gone; if the shred
+// stops early the metadata file records the file's temporary name, and
+// calling again with the same path resumes where it left off. Recover
+// gives the file its original name back instead.
+func shred(ctx context.Context, path string, plan []pass, opts ShredOptions) (report ShredReport, err error) {
+	start := time.Now()
+	defer func() { report.Elapsed = time.Since(start) }()
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.Timeout, ErrTimeout)
+		defer cancel()
+		defer func() {
+			// Only our own deadline, not one the caller set, and only once
+			// when following a symlink
+			if errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrTimeout) && context.Cause(ctx) == ErrTimeout {
+				err = fmt.Errorf("%w: %s after %v: %w", ErrTimeout, path, opts.Timeout, err)
+			}
+		}()
+	}
+
+	err = opts.validate()
+	if err != nil {
+		return report, err
+	}
+
+	fsys := opts.fs()
+	// System calls that take a path only reach the file on the system's
+	// own filesystem; elsewhere the checks and features that need them are
+	// skipped
+	native := systemPaths(fsys)
+	path, err = checkPath(fsys, path)
+	if err != nil {
+		return report, err
+	}
+	// The metadata records the temporary name, which must not depend on
+	// the working directory
+	if opts.WorkDir != "" {
+		opts.WorkDir, err = filepath.Abs(opts.WorkDir)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Shredding through a symlink must be asked for, since it destroys a
+	// file under another name
+	linfo, lerr := fsys.Lstat(path)
+	if lerr == nil && linfo.Mode()&os.ModeSymlink != 0 {
+		if !opts.FollowSymlinks {
+			target, _ := fsys.Readlink(path)
+			return report, fmt.Errorf("%w: %s points to %s, set FollowSymlinks to shred the target", ErrSymlink, path, target)
+		}
+		target, err := resolveLinks(fsys, path)
+		if err != nil {
+			return report, err
+		}
+		report, err = shred(ctx, target, plan, opts)
+		if err != nil {
+			return report, err
+		}
+		if opts.DryRun {
+			opts.logger().Info("would remove symlink", "path", path)
+			return report, nil
+		}
+		return report, fsys.Remove(path)
+	}
+
+	// Load metadata if it exists
+	metaPath, err := metadataPath(path, opts.metadataDir())
+	if err != nil {
+		return report, err
+	}
+	metadata, err := loadMetadata(fsys, metaPath)
+	if err == nil {
+		if metadata.PathHash != hashPath(metadata.Salt, path) {
+			return report, fmt.Errorf("metadata file %s does not belong to %s", metaPath, path)
+		}
+		report.Resumed = true
+	}
+	// Metadata whose file is gone is left over from a shred that removed
+	// the file but not the metadata. This shred replaces it when it saves
+	// its own; CleanOrphans removes it if nothing is left to shred.
+	if report.Resumed && metadata.TempPath != "" {
+		if _, err := fsys.Lstat(metadata.TempPath); os.IsNotExist(err) {
+			opts.logger().Info("ignoring stale metadata", "path", metaPath)
+			report.Resumed = false
+		}
+	}
+	if !report.Resumed {
+		metadata, err = newMetadata(opts.randSource(), path)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// When resuming, the file has already been moved to its temporary name
+	statPath := path
+	if metadata.TempPath != "" {
+		statPath = metadata.TempPath
+	}
+	info, err := fsys.Stat(statPath)
+	if err != nil {
+		return report, err
+	}
+	if report.Resumed && metadata.Inode != 0 {
+		if id := inode(info); (id != 0 && id != metadata.Inode) || info.Size() != metadata.Size {
+			return report, fmt.Errorf("%w: %s is not the file %s was shredding; use Recover or PurgeMetadata", ErrStaleMetadata, statPath, metaPath)
+		}
+	}
+	// Opening a FIFO blocks until a writer shows up, and devices and
+	// sockets have no contents of their own to overwrite
+	if !info.Mode().IsRegular() {
+		return report, fmt.Errorf("%w: %s is %s", ErrUnsupportedFileType, path, fileType(info.Mode()))
+	}
+	if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+		return report, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrSizeLimit, path, info.Size(), opts.MaxSize)
+	}
+	// Overwriting destroys the data under every name, but only this one
+	// is removed
+	if links := linkCount(info); links > 1 {
+		if !opts.ForceHardlinked && !opts.Force {
+			return report, fmt.Errorf("%w: %s has %d links", ErrHardLinked, path, links)
+		}
+		opts.logger().Warn("file has other hard links, which will remain with the contents destroyed", "path", path, "links", links)
+	}
+	// Don't give a false sense of security where overwrites may not land
+	// on the original blocks
+	fsType := ""
+	if native {
+		fsType, _ = unreliableFilesystem(statPath)
+	}
+	if fsType != "" {
+		if !opts.Force {
+			return report, fmt.Errorf("%w: %s is on %s, use Force to shred it anyway", ErrUnreliableFilesystem, path, fsType)
+		}
+		opts.logger().Warn("overwriting in place is unreliable on this filesystem", "path", path, "filesystem", fsType)
+	}
+	report.Size = info.Size()
+	if !report.Resumed {
+		metadata.Mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		metadata.UID, metadata.GID, _ = owner(info)
+		report.OriginalInfo = FileRecord{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+			UID:     metadata.UID,
+			GID:     metadata.GID,
+		}
+	}
+
+	// Pick the decoy size now so a bad range fails before anything is touched
+	var finalSize int64
+	if opts.RandomSizeMax > 0 {
+		finalSize, err = randomSize(opts.randSource(), opts.RandomSizeMin, opts.RandomSizeMax)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Describe the plan without touching anything
+	if opts.DryRun {
+		opts.logger().Info("would shred", "path", path, "size", info.Size(),
+			"passes", len(plan), "renames", len(opts.renameLengths(len(filepath.Base(path)))), "device", deviceID(info))
+		return report, nil
+	}
+
+	// Overwriting in place is unreliable on SSDs because of wear leveling
+	if !opts.SSDTrim && native {
+		if rotational, err := isRotational(info); err == nil && !rotational {
+			opts.logger().Warn("file is on a non-rotational device, overwriting may not reach the original blocks; consider SSDTrim", "path", path)
+		}
+	}
+
+	// Opening for writing needs the write permission. The mode is put back
+	// if the shred fails, so a file refused by a later check is left as it
+	// was, and when the file is kept.
+	if opts.Force && info.Mode().Perm()&0200 == 0 {
+		mode := info.Mode().Perm()
+		err = fsys.Chmod(statPath, 0600)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			if err == nil && !opts.KeepFile {
+				return
+			}
+			current := statPath
+			if metadata.TempPath != "" {
+				current = metadata.TempPath
+			}
+			fsys.Chmod(current, mode)
+		}()
+	}
+
+	// Check if another process is locking the file, giving a brief holder
+	// such as a virus scanner the configured chances to let go. When
+	// resuming, the holder is most likely another shred of the same file.
+	for retry := 0; retry < opts.LockRetries && isFileLocked(fsys, statPath); retry++ {
+		opts.logger().Debug("file is locked, retrying", "path", statPath, "retry", retry+1)
+		timer := time.NewTimer(opts.LockRetryDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return report, ctx.Err()
+		}
+	}
+	if isFileLocked(fsys, statPath) {
+		opts.logger().Warn("file is locked by another process", "path", statPath)
+		if report.Resumed {
+			return report, fmt.Errorf("%w: %s", ErrAlreadyShredding, path)
+		}
+		return report, fmt.Errorf("%w: %s", ErrFileLocked, path)
+	}
+
+	// Open the file for writing, and reading back for verification
+	tempFile, err := fsys.OpenFile(statPath, os.O_RDWR, 0)
+	if err != nil {
+		return report, err
+	}
+	defer tempFile.Close()
+
+	// Acquire the lock on the file. Another shred may have taken it since
+	// the check above; waiting for it would only find the file gone.
+	err = tryLockFile(tempFile)
+	if err != nil {
+		return report, fmt.Errorf("%w: %s", ErrAlreadyShredding, path)
+	}
+	defer unlockFile(tempFile)
+
+	// The lock is what keeps shreds of one file apart, but the metadata was
+	// loaded and the file opened before it was taken. If another shred
+	// got in between, it has moved the file since, so it is no longer the
+	// one at statPath.
+	opened, err := tempFile.Stat()
+	if err != nil {
+		return report, err
+	}
+	if current, err := fsys.Stat(statPath); err != nil || !sameFile(opened, current) {
+		return report, fmt.Errorf("%w: %s", ErrAlreadyShredding, path)
+	}
+
+	// Another writer may have extended the file since it was first looked
+	// at; from here on, with the lock held, go by its current size
+	info = opened
+	if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+		return report, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrSizeLimit, path, info.Size(), opts.MaxSize)
+	}
+	report.Size = info.Size()
+
+	// Only a shred holding the lock may give up on the file, so one refused
+	// because another is running never gets here
+	if opts.CleanMetadataOnError {
+		defer func() {
+			if err == nil || metadata.TempPath == "" {
+				return
+			}
+			if _, lerr := fsys.Lstat(path); !os.IsNotExist(lerr) {
+				return
+			}
+			if fsys.Rename(metadata.TempPath, path) == nil {
+				restoreAttributes(fsys, path, metadata)
+				fsys.Remove(metaPath)
+			}
+		}()
+	}
+
+	// Work out which parts of the file to overwrite
+	regions := wholeFile(info.Size())
+	if opts.SkipHoles {
+		regions, err = dataRegions(tempFile, info.Size())
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// The last pass also overwrites the slack between the end of the file
+	// and the end of its last block, which may still hold older data
+	end := info.Size()
+	lastRegions := regions
+	var block int64
+	if native {
+		block, err = blockSize(statPath)
+		if err != nil {
+			opts.logger().Warn("can't find the block size, leaving the slack of the last block", "path", path, "err", err)
+			block = 0
+		}
+	}
+	if block > 0 && end%block != 0 {
+		end += block - end%block
+		lastRegions = append(regions[:len(regions):len(regions)], region{Offset: info.Size(), Length: end - info.Size()})
+	}
+
+	// Record what is about to be destroyed while it is still there
+	if opts.HashContents && !report.Resumed {
+		hash := sha256.New()
+		_, err = io.Copy(hash, io.NewSectionReader(tempFile, 0, info.Size()))
+		if err != nil {
+			return report, fmt.Errorf("hash contents: %w", err)
+		}
+		report.ContentHash = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	// An empty file has no contents to destroy, only a name, so it skips the
+	// passes. It is still tracked in a metadata file, which is all that
+	// records its temporary name if the shred stops after the rename.
+	empty := info.Size() == 0 && !report.Resumed
+	save := func() error {
+		current, err := tempFile.Stat()
+		if err != nil {
+			return err
+		}
+		metadata.Inode = inode(current)
+		metadata.Size = current.Size()
+		return saveMetadata(fsys, opts.randSource(), metaPath, metadata)
+	}
+
+	writer, err := newPassWriter(tempFile, opts)
+	if err != nil {
+		return report, err
+	}
+	if opts.DirectIO && !empty {
+		direct, err := writer.directIO(statPath, block)
+		if err != nil {
+			opts.logger().Warn("direct I/O unavailable, writing through the page cache", "path", path, "err", err)
+		} else {
+			defer direct.Close()
+			// Every pass then covers whole blocks, slack included
+			regions = alignRegions(regions, block)
+			lastRegions = regions
+		}
+	}
+
+	// Run the passes from first on, the last one covering the slack too
+	overwrite := func(first int64, done func(int64) error) error {
+		if empty {
+			return nil
+		}
+		last := max(int64(len(plan))-1, 0)
+		err := runPasses(ctx, writer, regions, plan[:last], first, opts, &report, done)
+		if err != nil {
+			return err
+		}
+		return runPasses(ctx, writer, lastRegions, plan, max(report.PassesCompleted, last), opts, &report, done)
+	}
+
+	// Extended attributes aren't part of the contents, so the passes leave
+	// them alone; remove them instead
+	wipeXattrs := func(name string) {
+		if !opts.WipeXattrs {
+			return
+		}
+		if !native {
+			opts.logger().Warn("can't list extended attributes of a file outside the operating system's filesystem", "path", path)
+			return
+		}
+		names, err := listXattrs(name)
+		if err != nil {
+			opts.logger().Warn("can't list extended attributes, leaving them", "path", path, "err", err)
+			return
+		}
+		report.Xattrs = names
+		for _, attr := range names {
+			err = removeXattr(name, attr)
+			if err != nil {
+				opts.logger().Warn("can't remove extended attribute", "path", path, "name", attr, "err", err)
+			}
+		}
+	}
+
+	// A file being kept is overwritten under its own name, with no metadata
+	// to resume from, then emptied back to its original size
+	if opts.KeepFile {
+		err = overwrite(0, func(completed int64) error {
+			report.PassesCompleted = completed
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+		wipeXattrs(path)
+		err = tempFile.Truncate(0)
+		if err != nil {
+			return report, err
+		}
+		err = tempFile.Truncate(info.Size())
+		if err != nil {
+			return report, err
+		}
+		if !opts.SkipSync {
+			err = tempFile.Sync()
+			if err != nil {
+				return report, err
+			}
+		}
+		report.FinalPath = path
+		return report, nil
+	}
+
+	// Everything that could fail without harm has been checked, so only now
+	// move the file to a temporary name. Failing before this point leaves
+	// the file untouched; from here on its contents are being destroyed and
+	// the metadata records where it is, so a later call can resume.
+	if metadata.TempPath == "" {
+		// Use a random name so the metadata doesn't reveal the original one
+		name, err := randomString(opts.randSource(), tempNameLength)
+		if err != nil {
+			return report, err
+		}
+		tempPath := filepath.Join(opts.renameDir(path), name+tempSuffix)
+		err = fsys.Rename(path, tempPath)
+		if errors.Is(err, syscall.EXDEV) {
+			// WorkDir is on another filesystem, so rename in place instead
+			opts.logger().Warn("can't rename across filesystems, renaming in the file's directory", "path", path, "workdir", opts.WorkDir)
+			tempPath = filepath.Join(filepath.Dir(path), name+tempSuffix)
+			err = fsys.Rename(path, tempPath)
+		}
+		if err != nil {
+			return report, fmt.Errorf("rename to temporary name: %w", err)
+		}
+		metadata.TempPath = tempPath
+		err = save()
+		if err != nil {
+			// Without metadata nothing would point at the new name
+			fsys.Rename(tempPath, path)
+			return report, err
+		}
+	}
+
+	// Overwrite the file contents multiple times, saving progress after
+	// every pass
+	report.PassesCompleted = metadata.Pass
+	err = overwrite(metadata.Pass, func(completed int64) error {
+		metadata.Pass = completed
+		report.PassesCompleted = completed
+		return save()
+	})
+	if err != nil {
+		return report, err
+	}
+	wipeXattrs(metadata.TempPath)
+
+	// Rename the file to random names multiple times
+	originalLen := len(filepath.Base(path))
+	for _, length := range opts.renameLengths(originalLen) {
+		newPath, err := renameTarget(fsys, opts.randSource(), filepath.Dir(metadata.TempPath),
+			length, opts.MinNameLength, opts.NameLength, originalLen)
+		if err != nil {
+			return report, err
+		}
+		if newPath == "" {
+			continue
+		}
+
+		err = fsys.Rename(metadata.TempPath, newPath)
+		if errors.Is(err, syscall.EXDEV) && filepath.Dir(newPath) != filepath.Dir(path) {
+			// Carry on in the file's own directory, which is where it was
+			opts.logger().Warn("can't rename across filesystems, renaming in the file's directory", "path", path, "target", newPath)
+			newPath, err = renameTarget(fsys, opts.randSource(), filepath.Dir(path),
+				length, opts.MinNameLength, opts.NameLength, originalLen)
+			if err != nil {
+				return report, err
+			}
+			if newPath == "" {
+				continue
+			}
+			err = fsys.Rename(metadata.TempPath, newPath)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		metadata.TempPath = newPath
+		report.Renames++
+		err = save()
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Resize to a random length and overwrite it to hide the original size
+	if opts.RandomSizeMax > 0 {
+		err = tempFile.Truncate(finalSize)
+		if err != nil {
+			return report, err
+		}
+		// A resume checks the size, so record the new one
+		err = save()
+		if err != nil {
+			return report, err
+		}
+		writer.progress = nil
+		writer.file = tempFile
+		_, err = writer.writePass(ctx, wholeFile(finalSize), pass{})
+		if err != nil {
+			return report, err
+		}
+		if !opts.SkipSync {
+			err = tempFile.Sync()
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// Let the filesystem discard the blocks, since on SSDs the overwrites
+	// may have landed elsewhere
+	if opts.SSDTrim {
+		err = punchHole(tempFile, 0, max(end, finalSize))
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Truncate the temporary file to 0 bytes
+	err = tempFile.Truncate(0)
+	if err != nil {
+		return report, err
+	}
+	err = save()
+	if err != nil {
+		return report, err
+	}
+
+	// Remove the file, then the metadata that points at it, so a failure
+	// in between never leaves the file under a name nothing records
+	err = fsys.Remove(metadata.TempPath)
+	if err != nil {
+		return report, err
+	}
+	report.FinalPath = metadata.TempPath
+
+	if !opts.KeepMetadata {
+		err = fsys.Remove(metaPath)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Make the renames and the unlink durable
+	if opts.Fsync {
+		err = syncDir(fsys, filepath.Dir(metadata.TempPath))
+		if err != nil {
+			return report, err
+		}
+		if filepath.Dir(metadata.TempPath) != filepath.Dir(path) {
+			err = syncDir(fsys, filepath.Dir(path))
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Abandon an interrupted shred of path: move the file back from its
+// temporary name, put back its permissions and owner, and remove the
+// metadata file. Contents already overwritten are not restored; only the
+// name and attributes are.
+func Recover(path string) error {
+	return RecoverWithOptions(path, DefaultOptions())
+}
+
+// Like Recover, for a shred run with opts, whose MetadataDir or WorkDir
+// say where its metadata is
+func RecoverWithOptions(path string, opts ShredOptions) error {
+	fsys := opts.fs()
+	metaPath, metadata, err := interruptedShred(path, opts)
+	if err != nil {
+		return err
+	}
+
+	if metadata.TempPath != "" {
+		if isFileLocked(fsys, metadata.TempPath) {
+			return fmt.Errorf("%w: %s", ErrAlreadyShredding, path)
+		}
+		if _, err := fsys.Lstat(path); err == nil {
+			return fmt.Errorf("cannot recover %s: the path exists", path)
+		}
+		err = fsys.Rename(metadata.TempPath, path)
+		if err != nil {
+			return err
+		}
+		err = restoreAttributes(fsys, path, metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fsys.Remove(metaPath)
+}
+
+// Remove the metadata file of an abandoned shred of path, so the next shred
+// starts over. Refuses while another shred is working on the file. A file
+// still under its temporary name is left there; use Recover to get it back
+// under its original name instead.
+func PurgeMetadata(path string) error {
+	return PurgeMetadataWithOptions(path, DefaultOptions())
+}
+
+// Like PurgeMetadata, for a shred run with opts, whose MetadataDir or
+// WorkDir say where its metadata is
+func PurgeMetadataWithOptions(path string, opts ShredOptions) error {
+	metaPath, metadata, err := interruptedShred(path, opts)
+	if err != nil {
+		return err
+	}
+	if metadata.TempPath != "" && isFileLocked(opts.fs(), metadata.TempPath) {
+		return fmt.Errorf("%w: %s", ErrAlreadyShredding, path)
+	}
+	return opts.fs().Remove(metaPath)
+}
+
+// Load the metadata of an interrupted shred of path from where a shred with
+// opts keeps it, making sure it belongs to path
+func interruptedShred(path string, opts ShredOptions) (string, ShredMetadata, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", ShredMetadata{}, err
+	}
+	metaPath, err := metadataPath(path, opts.metadataDir())
+	if err != nil {
+		return "", ShredMetadata{}, err
+	}
+	metadata, err := loadMetadata(opts.fs(), metaPath)
+	if err != nil {
+		return "", ShredMetadata{}, fmt.Errorf("no interrupted shred of %s: %w", path, err)
+	}
+	if metadata.PathHash != hashPath(metadata.Salt, path) {
+		return "", ShredMetadata{}, fmt.Errorf("metadata file %s does not belong to %s", metaPath, path)
+	}
+	return metaPath, metadata, nil
+}
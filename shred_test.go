@@ -0,0 +1,1989 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	mrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Where a shred with no MetadataDir keeps the metadata for path
+func testMetadataPath(t *testing.T, path string) string {
+	t.Helper()
+	metaPath, err := metadataPath(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metaPath
+}
+
+// Create a file of the given size filled with non-zero bytes
+func writeTestFile(t *testing.T, path string, size int64) {
+	t.Helper()
+	err := os.WriteFile(path, bytes.Repeat([]byte{0xAB}, int(size)), 0600)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+}
+
+func TestShred(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		wantErr bool
+		// Prepares path after it was created, returning a path that must
+		// still exist after the shred, if any
+		setup func(t *testing.T, path string) string
+	}{
+		{name: "Small file", size: 128},
+		{name: "Large file", size: 1024 * 1024},
+		{name: "Empty file", size: 0},
+		{
+			name:    "Non-existent file",
+			wantErr: true,
+			setup: func(t *testing.T, path string) string {
+				os.Remove(path)
+				return ""
+			},
+		},
+		{
+			name:    "Read-only file",
+			size:    128,
+			wantErr: true,
+			setup: func(t *testing.T, path string) string {
+				if os.Geteuid() == 0 {
+					t.Skip("root can write to read-only files")
+				}
+				err := os.Chmod(path, 0444)
+				if err != nil {
+					t.Fatalf("Failed to set read-only permission: %v", err)
+				}
+				return path
+			},
+		},
+		{
+			// Refused, since FollowSymlinks isn't set; both are kept
+			name:    "Symbolic link",
+			size:    128,
+			wantErr: true,
+			setup: func(t *testing.T, path string) string {
+				target := path + "_target"
+				err := os.Rename(path, target)
+				if err != nil {
+					t.Fatalf("Failed to rename file: %v", err)
+				}
+				err = os.Symlink(target, path)
+				if err != nil {
+					t.Fatalf("Failed to create symlink: %v", err)
+				}
+				return target
+			},
+		},
+		{
+			// Refused, since the data would survive under the other name
+			name:    "Hard link",
+			size:    128,
+			wantErr: true,
+			setup: func(t *testing.T, path string) string {
+				err := os.Link(path, path+"_target")
+				if err != nil {
+					t.Fatalf("Failed to create hard link: %v", err)
+				}
+				return path
+			},
+		},
+		{
+			name:    "Locked file",
+			size:    128,
+			wantErr: true,
+			setup: func(t *testing.T, path string) string {
+				file, err := os.OpenFile(path, os.O_RDWR, 0)
+				if err != nil {
+					t.Fatalf("Failed to open file for locking: %v", err)
+				}
+				t.Cleanup(func() { file.Close() })
+				err = tryLockFile(file)
+				if err != nil {
+					t.Fatalf("Failed to lock file: %v", err)
+				}
+				return path
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "shredtest")
+			writeTestFile(t, path, tt.size)
+
+			var keep string
+			if tt.setup != nil {
+				keep = tt.setup(t, path)
+			}
+
+			err := Shred(path, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Shred() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr {
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("File still exists after shred: %s", path)
+				}
+			}
+			if keep != "" {
+				if _, err := os.Stat(keep); err != nil {
+					t.Errorf("File was removed by shred: %s", keep)
+				}
+			}
+		})
+	}
+}
+
+// Of several simultaneous shreds of one file exactly one must succeed, and
+// the others must fail cleanly without leaving anything behind
+func TestShredConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 64*1024)
+
+	errs := make([]error, 8)
+	var wg sync.WaitGroup
+	wg.Add(len(errs))
+	for i := range errs {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = Shred(path, 3)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrAlreadyShredding), errors.Is(err, ErrFileLocked), os.IsNotExist(err):
+		default:
+			t.Errorf("Shred() error = %v, want ErrAlreadyShredding", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d shreds succeeded, want 1", succeeded)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Left behind after shred: %s", entry.Name())
+	}
+}
+
+// An empty file is removed without running any passes or leaving metadata
+func TestShredEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 0)
+
+	report, err := ShredWithReport(path, 3)
+	if err != nil {
+		t.Fatalf("ShredWithReport() error = %v", err)
+	}
+	if len(report.PassBytes) != 0 {
+		t.Errorf("ran %d passes over an empty file", len(report.PassBytes))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind for an empty file")
+	}
+
+	// Failing after the rename leaves metadata to get the file back with
+	writeTestFile(t, path, 0)
+	opts := DefaultOptions()
+	opts.FS = &testFS{failRemove: true}
+	err = ShredWithOptions(path, opts)
+	if err == nil {
+		t.Fatal("ShredWithOptions() succeeded with failing removals")
+	}
+	err = Recover(path)
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Recover() didn't bring the empty file back: %v", err)
+	}
+}
+
+// A zero pass must leave nothing but zero bytes behind
+func TestZeroFillPass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	size := int64(1024 * 1024)
+	writeTestFile(t, path, size)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := &passWriter{file: file, buf: make([]byte, DefaultBufferSize)}
+	_, err = writer.writePass(context.Background(), wholeFile(size), PatternZero.pass())
+	if err != nil {
+		t.Fatalf("writePass() error = %v", err)
+	}
+
+	data := make([]byte, size)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		t.Fatalf("Failed to read back test file: %v", err)
+	}
+	if !bytes.Equal(data, make([]byte, size)) {
+		t.Error("Zero-fill pass left non-zero bytes")
+	}
+}
+
+// A 2GB sparse file is refused over the limit and shredded without one
+// The limit is an option, so a small file over a small limit exercises it
+// without gigabytes of I/O
+func TestSizeLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 2*1024*1024)
+
+	err := ShredWithOptions(path, ShredOptions{Passes: 1, MaxSize: 1024 * 1024})
+	if !errors.Is(err, ErrSizeLimit) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrSizeLimit", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("File over MaxSize was touched: %v", err)
+	}
+
+	err = ShredWithOptions(path, ShredOptions{Passes: 1})
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+}
+
+// The metadata file must not contain the name of the file being shredded
+func TestMetadataHidesName(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret-report.pdf")
+	writeTestFile(t, path, 128)
+
+	// The zero value cleans up after itself
+	err := ShredWithOptions(path, ShredOptions{Passes: 1})
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%s left behind by zero-valued options", entries[0].Name())
+	}
+
+	writeTestFile(t, path, 128)
+	err = ShredWithOptions(path, ShredOptions{Passes: 1, KeepMetadata: true})
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testMetadataPath(t, path))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if bytes.Contains(data, []byte(filepath.Base(path))) {
+		t.Errorf("Metadata contains the file name: %s", data)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), filepath.Base(path)) {
+			t.Errorf("%s left beside the file names it", entry.Name())
+		}
+	}
+}
+
+// With NoRename the file goes straight from its temporary name to removal
+func TestShredNoRename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	opts := DefaultOptions()
+	opts.NoRename = true
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if report.Renames != 0 {
+		t.Errorf("Renames = %d, want 0", report.Renames)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+
+	// Options built from scratch rename unless told not to
+	writeTestFile(t, path, 128)
+	opts = ShredOptions{Passes: 1, DescendingRenames: true}.withDefaults()
+	report, err = shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if report.Renames == 0 {
+		t.Error("zero-valued NoRename skipped the renames")
+	}
+}
+
+// Every character of the charset must be about equally likely
+func TestRandomStringUniform(t *testing.T) {
+	const charsetSize = 62
+	const perChar = 2000
+
+	counts := map[rune]int{}
+	s, err := randomString(rand.Reader, charsetSize*perChar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range s {
+		counts[c]++
+	}
+
+	if len(counts) != charsetSize {
+		t.Errorf("got %d distinct characters, want %d", len(counts), charsetSize)
+	}
+	// Modulo bias would make some characters 25% more likely
+	for c, n := range counts {
+		if n < perChar*85/100 || n > perChar*115/100 {
+			t.Errorf("character %q appeared %d times, want about %d", c, n, perChar)
+		}
+	}
+}
+
+// An FS that counts the renames of the shredded file and can be made to
+// fail renames and removals
+type testFS struct {
+	osFS
+	renames    int
+	targets    []string // Names the file was renamed to, in order
+	failRename bool
+	failRemove bool
+	grow       int64  // Bytes appended to the file right after its first Stat
+	otherFS    string // Renames into this directory fail with EXDEV
+}
+
+func (fsys *testFS) Stat(name string) (os.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err == nil && fsys.grow > 0 {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		_, err = file.Write(bytes.Repeat([]byte{0xCD}, int(fsys.grow)))
+		if err != nil {
+			return nil, err
+		}
+		fsys.grow = 0
+	}
+	return info, err
+}
+
+func (fsys *testFS) Rename(oldpath, newpath string) error {
+	if fsys.failRename {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	if fsys.otherFS != "" && filepath.Dir(newpath) == fsys.otherFS && filepath.Dir(oldpath) != fsys.otherFS {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	// Metadata is saved by renaming it into place
+	if !strings.HasSuffix(newpath, metadataSuffix) {
+		fsys.renames++
+		fsys.targets = append(fsys.targets, filepath.Base(newpath))
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+func (fsys *testFS) Remove(name string) error {
+	if fsys.failRemove {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return os.Remove(name)
+}
+
+// The file must be renamed exactly RenamePasses times, after the move to
+// its temporary name
+func TestRenamePasses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	fsys := &testFS{}
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.RenamePasses = 4
+	opts.FS = fsys
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if report.Renames != 4 {
+		t.Errorf("Renames = %d, want 4", report.Renames)
+	}
+	if fsys.renames != 5 {
+		t.Errorf("Rename called %d times, want 5", fsys.renames)
+	}
+	if last := fsys.targets[len(fsys.targets)-1]; filepath.Base(report.FinalPath) != last {
+		t.Errorf("FinalPath = %s, want the last rename target %s", report.FinalPath, last)
+	}
+}
+
+// A failed rename leaves the file as it was; a failed removal leaves
+// metadata a later shred resumes from
+func TestShredFSFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.FS = &testFS{failRename: true}
+	err := ShredWithOptions(path, opts)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("ShredWithOptions() error = %v, want the rename error", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte{0xAB}, 128)) {
+		t.Errorf("File changed by a shred that failed to rename it: %v", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind by a failed rename")
+	}
+
+	opts.FS = &testFS{failRemove: true}
+	err = ShredWithOptions(path, opts)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("ShredWithOptions() error = %v, want the remove error", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); err != nil {
+		t.Fatalf("Metadata file missing after a failed removal: %v", err)
+	}
+
+	opts.FS = nil
+	report, err := shred(context.Background(), path, opts.withDefaults().plan(), opts.withDefaults())
+	if err != nil {
+		t.Fatalf("shred() resume error = %v", err)
+	}
+	if !report.Resumed {
+		t.Error("shred() did not resume from the metadata")
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind after resume")
+	}
+}
+
+// A shred over its Timeout stops with ErrTimeout and can be resumed
+func TestShredTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 1024*1024)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.BytesPerSecond = 1024 * 1024
+	opts.Timeout = 100 * time.Millisecond
+	err := ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); err != nil {
+		t.Fatalf("Metadata file missing after timeout: %v", err)
+	}
+
+	opts.Timeout = 0
+	opts.BytesPerSecond = 0
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() resume error = %v", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind after resume")
+	}
+}
+
+// A read-only file is refused unless Force makes it writable
+func TestShredForce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+	err := os.Chmod(path, 0444)
+	if err != nil {
+		t.Fatalf("Failed to set read-only permission: %v", err)
+	}
+
+	if os.Geteuid() != 0 {
+		err = Shred(path, 1)
+		if !os.IsPermission(err) {
+			t.Fatalf("Shred() error = %v, want a permission error", err)
+		}
+	}
+
+	// A refused shred, or one keeping the file, leaves the mode as it was
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = tryLockFile(file)
+	if err != nil {
+		t.Fatalf("Failed to lock file: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.Force = true
+	err = ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrFileLocked) {
+		t.Errorf("ShredWithOptions() error = %v, want ErrFileLocked", err)
+	}
+	unlockFile(file)
+	keep := opts
+	keep.KeepFile = true
+	err = ShredWithOptions(path, keep)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() keeping the file error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("mode after refused and kept shreds = %v, want 0444", info.Mode().Perm())
+	}
+
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+}
+
+// A dry run touches nothing and describes the plan through the logger
+func TestDryRunLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	var logs bytes.Buffer
+	opts := DefaultOptions()
+	opts.DryRun = true
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+
+	if !strings.Contains(logs.String(), "would shred") {
+		t.Errorf("dry run logged %q, want the plan", logs.String())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Dry run removed the file: %s", path)
+	}
+}
+
+// The reported hash must be that of the contents before the shred
+func TestHashContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+
+	opts := DefaultOptions()
+	opts.HashContents = true
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if want := hex.EncodeToString(sum[:]); report.ContentHash != want {
+		t.Errorf("ContentHash = %s, want %s", report.ContentHash, want)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	err := SelfTest()
+	if err != nil {
+		t.Errorf("SelfTest() error = %v", err)
+	}
+}
+
+// The benchmark file is fully overwritten, timed and gone afterwards
+func TestShredBenchmark(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	size := int64(1024 * 1024)
+	report, err := ShredBenchmark(size, 2)
+	if err != nil {
+		t.Fatalf("ShredBenchmark() error = %v", err)
+	}
+	if report.Size != size || report.TotalBytes < 2*size || report.Elapsed <= 0 {
+		t.Errorf("report = %+v, want 2 passes over %d bytes", report, size)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d entries left in the temporary directory", len(entries))
+	}
+}
+
+// A failed shred leaves the file under its name and no metadata behind
+func TestCleanMetadataOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 1024*1024)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.BytesPerSecond = 1024 * 1024
+	opts.Timeout = 100 * time.Millisecond
+	opts.CleanMetadataOnError = true
+	err := ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("File not moved back to its name: %v", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind after a failed shred")
+	}
+}
+
+// PurgeMetadata removes the metadata of an abandoned shred only
+func TestPurgeMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 1024*1024)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.BytesPerSecond = 1024 * 1024
+	opts.Timeout = 100 * time.Millisecond
+	err := ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+
+	err = PurgeMetadata(path)
+	if err != nil {
+		t.Fatalf("PurgeMetadata() error = %v", err)
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind after purge")
+	}
+
+	err = PurgeMetadata(path)
+	if err == nil {
+		t.Error("PurgeMetadata() without metadata succeeded")
+	}
+}
+
+// A random pass writes exactly what RandSource produces
+func TestRandSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	size := int64(256 * 1024)
+	writeTestFile(t, path, size)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	seed := [32]byte{1, 2, 3}
+	opts := ShredOptions{RandSource: mrand.NewChaCha8(seed)}.withDefaults()
+	_, err = testPassWriter(t, file, opts).writePass(context.Background(), wholeFile(size), pass{})
+	if err != nil {
+		t.Fatalf("writePass() error = %v", err)
+	}
+
+	want := make([]byte, size)
+	mrand.NewChaCha8(seed).Read(want)
+	data := make([]byte, size)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		t.Fatalf("Failed to read back test file: %v", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Error("Random pass differs from the RandSource stream")
+	}
+}
+
+type failReader struct{}
+
+func (failReader) Read(p []byte) (int, error) {
+	return 0, errors.New("crypto/rand read")
+}
+
+// With RandSource set, a shred draws nothing from crypto/rand, so a seeded
+// source makes the whole run reproducible
+func TestRandSourceOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+
+	saved := rand.Reader
+	rand.Reader = failReader{}
+	defer func() { rand.Reader = saved }()
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.RandomSizeMax = 8192
+	opts.RandSource = mrand.NewChaCha8([32]byte{1})
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+}
+
+// Paths are cleaned before use, and directories are refused
+func TestShredPathChecks(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	err := os.Mkdir(sub, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{sub, sub + string(filepath.Separator), string(filepath.Separator)} {
+		err = Shred(path, 1)
+		if err == nil {
+			t.Errorf("Shred(%q) succeeded on a directory", path)
+		}
+	}
+	if _, err := os.Stat(sub); err != nil {
+		t.Errorf("Directory was removed: %s", sub)
+	}
+
+	// Whole trees that are never meant to go are refused before the walk;
+	// a dry run keeps a regression from destroying anything
+	home := filepath.Join(sub, "home")
+	err = os.Mkdir(home, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	for _, root := range []string{string(filepath.Separator), home, filepath.Join(home, ".")} {
+		err = ShredDirWithOptions(root, ShredOptions{DryRun: true})
+		if err == nil || !strings.Contains(err.Error(), "refusing") {
+			t.Errorf("ShredDirWithOptions(%q) error = %v, want a refusal", root, err)
+		}
+	}
+
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 128)
+	err = Shred(filepath.Join(sub, "..", "shredtest"), 1)
+	if err != nil {
+		t.Fatalf("Shred() with .. components error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+}
+
+// Time whole shreds for a range of file sizes and pass counts. Throughput
+// counts every pass, so it is comparable across pass counts.
+func BenchmarkShred(b *testing.B) {
+	sizes := []struct {
+		name string
+		size int64
+	}{
+		{"4KB", 4 * 1024},
+		{"1MB", 1024 * 1024},
+		{"64MB", 64 * 1024 * 1024},
+	}
+
+	for _, s := range sizes {
+		for _, passes := range []int64{1, 3, 7} {
+			b.Run(fmt.Sprintf("%s/%dpasses", s.name, passes), func(b *testing.B) {
+				data := bytes.Repeat([]byte{0xAB}, int(s.size))
+				path := filepath.Join(b.TempDir(), "bench")
+				b.SetBytes(s.size * passes)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					err := os.WriteFile(path, data, 0600)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.StartTimer()
+
+					err = Shred(path, passes)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Recover puts back the mode the file had before Force changed it
+func TestRecoverRestoresMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 1024*1024)
+	err := os.Chmod(path, 0444)
+	if err != nil {
+		t.Fatalf("Failed to set read-only permission: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.Force = true
+	opts.BytesPerSecond = 1024 * 1024
+	opts.Timeout = 100 * time.Millisecond
+	err = ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+
+	err = Recover(path)
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("File not recovered: %v", err)
+	}
+	if info.Mode().Perm() != 0444 {
+		t.Errorf("Recovered mode = %v, want %v", info.Mode().Perm(), os.FileMode(0444))
+	}
+}
+
+// An interrupted shred that kept its metadata elsewhere is found through
+// the same options
+func TestRecoverWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 1024*1024)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.MetadataDir = t.TempDir()
+	opts.WorkDir = t.TempDir()
+	opts.BytesPerSecond = 1024 * 1024
+	opts.Timeout = 100 * time.Millisecond
+	err := ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+
+	if err := Recover(path); err == nil {
+		t.Error("Recover() found metadata kept in MetadataDir")
+	}
+	err = RecoverWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("RecoverWithOptions() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("File not recovered: %v", err)
+	}
+
+	err = ShredWithOptions(path, opts)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("ShredWithOptions() error = %v, want ErrTimeout", err)
+	}
+	err = PurgeMetadataWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("PurgeMetadataWithOptions() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(opts.MetadataDir); len(entries) != 0 {
+		t.Errorf("%d entries left in MetadataDir", len(entries))
+	}
+}
+
+// Only the given range is overwritten, and ranges past the end are refused
+func TestShredRegion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+
+	err := ShredRegion(path, 1000, 500, 1)
+	if err != nil {
+		t.Fatalf("ShredRegion() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read back test file: %v", err)
+	}
+	if len(data) != 4096 {
+		t.Fatalf("File size changed to %d", len(data))
+	}
+	if !bytes.Equal(data[:1000], bytes.Repeat([]byte{0xAB}, 1000)) || !bytes.Equal(data[1500:], bytes.Repeat([]byte{0xAB}, 4096-1500)) {
+		t.Error("Bytes outside the region were changed")
+	}
+	if bytes.Equal(data[1000:1500], bytes.Repeat([]byte{0xAB}, 500)) {
+		t.Error("Region was not overwritten")
+	}
+
+	for _, r := range [][2]int64{{4000, 200}, {-1, 10}, {0, -1}} {
+		err = ShredRegion(path, r[0], r[1], 1)
+		if err == nil {
+			t.Errorf("ShredRegion(%d, %d) outside the file succeeded", r[0], r[1])
+		}
+	}
+}
+
+// Patterns are cycled when there are more passes than patterns
+func TestOverwritePatterns(t *testing.T) {
+	opts := ShredOptions{
+		Passes:    5,
+		Overwrite: OverwritePattern,
+		Patterns:  [][]byte{{0x00}, {0xFF}, {0xAA}},
+	}
+	want := [][]byte{{0x00}, {0xFF}, {0xAA}, {0x00}, {0xFF}}
+
+	plan := opts.withDefaults().plan()
+	if len(plan) != len(want) {
+		t.Fatalf("plan has %d passes, want %d", len(plan), len(want))
+	}
+	for i, p := range plan {
+		if !bytes.Equal(p.Pattern, want[i]) {
+			t.Errorf("pass %d pattern = %x, want %x", i+1, p.Pattern, want[i])
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+}
+
+// DoD is zeros, ones, then verified random data
+func TestShredDoD(t *testing.T) {
+	want := []pass{
+		{Pattern: []byte{0x00}},
+		{Pattern: []byte{0xFF}},
+		{Verify: true},
+	}
+	if len(dodPasses) != len(want) {
+		t.Fatalf("DoD plan has %d passes, want %d", len(dodPasses), len(want))
+	}
+	for i, p := range dodPasses {
+		if !bytes.Equal(p.Pattern, want[i].Pattern) || p.Verify != want[i].Verify {
+			t.Errorf("DoD pass %d = %+v, want %+v", i+1, p, want[i])
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 256*1024)
+	err := ShredDoD(path)
+	if err != nil {
+		t.Fatalf("ShredDoD() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+}
+
+// An FS whose files flip a byte at offset right after every write over it,
+// like media that don't keep what they were given
+type corruptFS struct {
+	osFS
+	offset int64
+}
+
+func (fsys corruptFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return corruptFile{file, fsys.offset}, nil
+}
+
+type corruptFile struct {
+	*os.File
+	offset int64
+}
+
+func (f corruptFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	if err == nil && off <= f.offset && f.offset < off+int64(n) {
+		_, err = f.File.WriteAt([]byte{^p[f.offset-off]}, f.offset)
+	}
+	return n, err
+}
+
+// Verify catches a write that didn't stick and says where
+func TestVerifyMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 256*1024)
+
+	const offset = 128*1024 + 5
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.Verify = true
+	opts.FS = corruptFS{offset: offset}
+	err := ShredWithOptions(path, opts)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("verification failed at offset %d", offset)) {
+		t.Fatalf("ShredWithOptions() error = %v, want a verification failure at offset %d", err, offset)
+	}
+
+	// Without Verify the corruption goes unnoticed
+	err = PurgeMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, 256*1024)
+	opts.Verify = false
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Errorf("ShredWithOptions() without Verify error = %v", err)
+	}
+}
+
+// The Gutmann plan is four random passes, the fixed table, four random
+func TestGutmannPasses(t *testing.T) {
+	plan := gutmannPasses()
+	if len(plan) != 35 {
+		t.Fatalf("Gutmann plan has %d passes, want 35", len(plan))
+	}
+	for i, p := range plan {
+		var want []byte
+		if i >= 4 && i < 31 {
+			want = gutmannPatterns[i-4]
+		}
+		if !bytes.Equal(p.Pattern, want) {
+			t.Errorf("pass %d pattern = %x, want %x", i+1, p.Pattern, want)
+		}
+	}
+
+	// The three-byte patterns must continue across buffer boundaries
+	path := filepath.Join(t.TempDir(), "shredtest")
+	size := int64(100 * 1000)
+	writeTestFile(t, path, size)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := &passWriter{file: file, buf: make([]byte, 4096)}
+	_, err = writer.writePass(context.Background(), wholeFile(size), plan[6])
+	if err != nil {
+		t.Fatalf("writePass() error = %v", err)
+	}
+	data := make([]byte, size)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		t.Fatalf("Failed to read back test file: %v", err)
+	}
+	for i, b := range data {
+		if b != plan[6].Pattern[i%3] {
+			t.Fatalf("byte %d = %#02x, want %#02x", i, b, plan[6].Pattern[i%3])
+		}
+	}
+}
+
+// A cancelled shred stops with the context's error, keeps its metadata and
+// can be resumed
+func TestShredContextCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 1024*1024)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := DefaultOptions()
+	opts.Passes = 3
+	opts.Progress = func(pass, bytesWritten, totalBytes int64) {
+		if pass == 2 {
+			cancel()
+		}
+	}
+	opts = opts.withDefaults()
+	_, err := shred(ctx, path, opts.plan(), opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("shred() error = %v, want context.Canceled", err)
+	}
+
+	metadata, err := loadMetadata(osFS{}, testMetadataPath(t, path))
+	if err != nil {
+		t.Fatalf("Metadata file missing after cancellation: %v", err)
+	}
+	if metadata.Pass != 1 {
+		t.Errorf("metadata records %d passes done, want 1", metadata.Pass)
+	}
+
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() resume error = %v", err)
+	}
+	if report.PassesCompleted != 3 || len(report.PassBytes) != 2 {
+		t.Errorf("resume completed %d passes running %d, want 3 running 2", report.PassesCompleted, len(report.PassBytes))
+	}
+	if _, err := os.Stat(testMetadataPath(t, path)); !os.IsNotExist(err) {
+		t.Errorf("Metadata file left behind after resume")
+	}
+}
+
+// A lock held through another descriptor in this process is detected
+func TestIsFileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	if isFileLocked(osFS{}, path) {
+		t.Fatal("isFileLocked() = true before locking")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = tryLockFile(file)
+	if err != nil {
+		t.Fatalf("Failed to lock file: %v", err)
+	}
+	if !isFileLocked(osFS{}, path) {
+		t.Error("isFileLocked() = false while locked")
+	}
+
+	err = unlockFile(file)
+	if err != nil {
+		t.Fatalf("Failed to unlock file: %v", err)
+	}
+	if isFileLocked(osFS{}, path) {
+		t.Error("isFileLocked() = true after unlocking")
+	}
+}
+
+// Symlinks are refused by default, and followed to the target on request
+func TestShredSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	link := filepath.Join(dir, "link")
+	writeTestFile(t, target, 128)
+	err := os.Symlink(target, link)
+	if err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	err = Shred(link, 1)
+	if !errors.Is(err, ErrSymlink) || !strings.Contains(err.Error(), target) {
+		t.Fatalf("Shred() error = %v, want ErrSymlink naming %s", err, target)
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.FollowSymlinks = true
+	err = ShredWithOptions(link, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	for _, path := range []string{link, target} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after shred", path)
+		}
+	}
+}
+
+// A hard-linked file is refused with its link count, unless forced
+func TestShredHardLinked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+	other := path + "_link"
+	err := os.Link(path, other)
+	if err != nil {
+		t.Fatalf("Failed to create hard link: %v", err)
+	}
+
+	err = Shred(path, 1)
+	if !errors.Is(err, ErrHardLinked) || !strings.Contains(err.Error(), "2 links") {
+		t.Fatalf("Shred() error = %v, want ErrHardLinked with 2 links", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.Force = true
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	data, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatalf("Other link was removed: %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Other link still has %d bytes", len(data))
+	}
+}
+
+// Descending renames go through every length from the original name's down
+func TestDescendingRenames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	fsys := &testFS{}
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.DescendingRenames = true
+	opts.MinNameLength = 2
+	opts.FS = fsys
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+
+	// The first rename is to the temporary name
+	targets := fsys.targets[1:]
+	if len(targets) != len("shredtest")-1 {
+		t.Fatalf("renamed to %v, want one name per length from 9 to 2", targets)
+	}
+	for i, name := range targets {
+		if want := len("shredtest") - i; len(name) != want {
+			t.Errorf("rename %d to %q, want a name of length %d", i+1, name, want)
+		}
+	}
+}
+
+// ShredFile overwrites an open file and leaves it in place
+func TestShredFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = ShredFile(file, 4096, 2)
+	if err != nil {
+		t.Fatalf("ShredFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("File was removed by ShredFile: %v", err)
+	}
+	if bytes.Equal(data, bytes.Repeat([]byte{0xAB}, 4096)) {
+		t.Error("File contents were not overwritten")
+	}
+}
+
+// Metadata pointing at a file that is gone doesn't stop a new shred
+func TestShredStaleMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 128)
+
+	metadata, err := newMetadata(rand.Reader, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Pass = 2
+	metadata.TempPath = filepath.Join(dir, "Gone0123abcd"+tempSuffix)
+	err = saveMetadata(osFS{}, rand.Reader, testMetadataPath(t, path), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ShredWithReport(path, 3)
+	if err != nil {
+		t.Fatalf("ShredWithReport() error = %v", err)
+	}
+	if report.Resumed || len(report.PassBytes) != 3 {
+		t.Errorf("resumed from stale metadata: %+v", report)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Left behind after shred: %s", entry.Name())
+	}
+}
+
+// A resume refuses a file under the temporary name that isn't the one the
+// metadata was saved for
+func TestShredReplacedFile(t *testing.T) {
+	for _, replace := range []string{"recreated", "resized"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "shredtest")
+		writeTestFile(t, path, 4096)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := DefaultOptions()
+		opts.Passes = 3
+		opts.Progress = func(pass int64, written, total int64) {
+			if pass == 2 {
+				cancel()
+			}
+		}
+		opts = opts.withDefaults()
+		_, err := shred(ctx, path, opts.plan(), opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("shred() error = %v, want context.Canceled", err)
+		}
+		metadata, err := loadMetadata(osFS{}, testMetadataPath(t, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Moved aside rather than removed, so its inode isn't reused
+		if replace == "recreated" {
+			os.Rename(metadata.TempPath, filepath.Join(dir, "old"))
+		}
+		file, err := os.OpenFile(metadata.TempPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replace == "recreated" {
+			file.Write(bytes.Repeat([]byte{0xAB}, 4096))
+		} else {
+			file.Write([]byte{0xAB})
+		}
+		file.Close()
+
+		opts.Progress = nil
+		_, err = shred(context.Background(), path, opts.plan(), opts)
+		if !errors.Is(err, ErrStaleMetadata) {
+			t.Errorf("%s: shred() error = %v, want ErrStaleMetadata", replace, err)
+		}
+		if _, err := os.Stat(metadata.TempPath); err != nil {
+			t.Errorf("%s: file was removed: %v", replace, err)
+		}
+	}
+}
+
+// Zero renames is valid; a negative rename count is refused before the
+// file is touched
+func TestNegativeRenamePasses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	// Zero is a count like any other, not a request for the default
+	if got := (ShredOptions{}).withDefaults().RenamePasses; got != 0 {
+		t.Errorf("withDefaults() turned zero RenamePasses into %d", got)
+	}
+	if got := DefaultOptions().RenamePasses; got != DefaultRenamePasses {
+		t.Errorf("DefaultOptions().RenamePasses = %d, want %d", got, DefaultRenamePasses)
+	}
+
+	opts := DefaultOptions()
+	opts.RenamePasses = -1
+	err := ShredWithOptions(path, opts)
+	if err == nil {
+		t.Fatal("ShredWithOptions() accepted a negative RenamePasses")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("File was touched: %v", err)
+	}
+}
+
+// A negative pass count is refused instead of removing the file without
+// overwriting it
+func TestNegativePasses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	shreds := map[string]func() error{
+		"Shred": func() error { return Shred(path, -1) },
+		"ShredWithReport": func() error {
+			_, err := ShredWithReport(path, -1)
+			return err
+		},
+		"ShredWithOptions": func() error { return ShredWithOptions(path, ShredOptions{Passes: -1}) },
+		"ShredRegion":      func() error { return ShredRegion(path, 0, 128, -1) },
+	}
+	for name, shred := range shreds {
+		if err := shred(); err == nil {
+			t.Errorf("%s() accepted a negative number of passes", name)
+		}
+		if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, bytes.Repeat([]byte{0xAB}, 128)) {
+			t.Fatalf("%s() touched the file: %v", name, err)
+		}
+	}
+}
+
+// Empty patterns are refused before the file is touched, instead of
+// failing halfway
+func TestEmptyPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	for _, opts := range []ShredOptions{
+		{Pattern: []byte{}},
+		{Overwrite: OverwritePattern, Patterns: [][]byte{{0xAA}, {}}},
+	} {
+		if err := ShredWithOptions(path, opts); err == nil {
+			t.Errorf("ShredWithOptions(%+v) accepted an empty pattern", opts)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		if data, err := os.ReadFile(path); err != nil || len(entries) != 1 || !bytes.Equal(data, bytes.Repeat([]byte{0xAB}, 128)) {
+			t.Fatalf("ShredWithOptions(%+v) touched the file: %v", opts, err)
+		}
+	}
+}
+
+// ShredDevice needs confirmation and refuses anything but a device
+func TestShredDeviceGuards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	for _, opts := range []DeviceOptions{{}, {Confirm: true}, {Confirm: true, Force: true}} {
+		err := ShredDevice(path, 1, opts)
+		if err == nil {
+			t.Errorf("ShredDevice(%+v) accepted a regular file", opts)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte{0xAB}, 128)) {
+		t.Errorf("File was touched: %v", err)
+	}
+}
+
+// The Gutmann mode runs the Gutmann plan whatever Passes says
+func TestOverwriteGutmann(t *testing.T) {
+	opts := ShredOptions{Passes: 3, Overwrite: OverwriteGutmann}.withDefaults()
+	if plan := opts.plan(); len(plan) != 35 {
+		t.Errorf("Gutmann mode plan has %d passes, want 35", len(plan))
+	}
+}
+
+// The DoD mode runs the same plan as ShredDoD, with Verify adding checks to
+// the passes that don't have one
+func TestOverwriteDoD(t *testing.T) {
+	opts := ShredOptions{Passes: 5, Overwrite: OverwriteDoD}.withDefaults()
+	plan := opts.plan()
+	if len(plan) != len(dodPasses) {
+		t.Fatalf("DoD mode plan has %d passes, want %d", len(plan), len(dodPasses))
+	}
+	for i, p := range plan {
+		if !bytes.Equal(p.Pattern, dodPasses[i].Pattern) || p.Verify != dodPasses[i].Verify {
+			t.Errorf("DoD mode pass %d = %+v, want %+v", i+1, p, dodPasses[i])
+		}
+	}
+
+	opts.Verify = true
+	for i, p := range opts.plan() {
+		if !p.Verify {
+			t.Errorf("DoD mode pass %d isn't verified with Verify set", i+1)
+		}
+	}
+	if dodPasses[0].Verify {
+		t.Error("Verify changed the ShredDoD plan")
+	}
+}
+
+// The report records the file as it was before the shred
+func TestOriginalInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err := os.Chtimes(path, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ShredWithReport(path, 1)
+	if err != nil {
+		t.Fatalf("ShredWithReport() error = %v", err)
+	}
+	info := report.OriginalInfo
+	if info.Name != "shredtest" || info.Size != 4096 || info.Mode.Perm() != 0600 || !info.ModTime.Equal(mtime) {
+		t.Errorf("OriginalInfo = %+v, want shredtest, 4096 bytes, 0600, %v", info, mtime)
+	}
+}
+
+// A lock released while retrying doesn't fail the shred
+func TestLockRetries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = tryLockFile(file)
+	if err != nil {
+		t.Fatalf("Failed to lock file: %v", err)
+	}
+	// The file is closed by the deferred Close, which must wait for the
+	// unlock
+	unlocked := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() {
+		unlockFile(file)
+		close(unlocked)
+	})
+	defer func() { <-unlocked }()
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.LockRetries = 50
+	opts.LockRetryDelay = 20 * time.Millisecond
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", path)
+	}
+}
+
+// FinalZeroPass appends a zero pass to any plan and leaves zeros behind
+func TestFinalZeroPass(t *testing.T) {
+	for _, opts := range []ShredOptions{
+		{Passes: 2},
+		{PassPatterns: []PassPattern{PatternOne}},
+		{Overwrite: OverwriteGutmann},
+	} {
+		base := len(opts.withDefaults().plan())
+		opts.FinalZeroPass = true
+		plan := opts.withDefaults().plan()
+		if len(plan) != base+1 || !bytes.Equal(plan[len(plan)-1].Pattern, []byte{0x00}) {
+			t.Errorf("plan with FinalZeroPass = %+v, want %d passes ending in zeros", plan, base+1)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "shredtest")
+	size := int64(64 * 1024)
+	writeTestFile(t, path, size)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	opts := ShredOptions{Passes: 1, FinalZeroPass: true}.withDefaults()
+	var report ShredReport
+	err = runPasses(context.Background(), testPassWriter(t, file, opts), wholeFile(size), opts.plan(), 0, opts, &report, nil)
+	if err != nil {
+		t.Fatalf("runPasses() error = %v", err)
+	}
+	data := make([]byte, size)
+	_, err = file.ReadAt(data, 0)
+	if err != nil {
+		t.Fatalf("Failed to read back test file: %v", err)
+	}
+	if !bytes.Equal(data, make([]byte, size)) {
+		t.Error("File doesn't end up all zeros")
+	}
+}
+
+// Every byte is overwritten: holes of sparse files, and data appended after
+// the file was first looked at
+func TestShredFullSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	size := int64(1024 * 1024)
+	file.Truncate(size)
+	file.WriteAt([]byte("secret"), size-6)
+	file.Close()
+
+	opts := DefaultOptions()
+	opts.Passes = 2
+	opts.FS = &testFS{grow: 4096}
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if want := size + 4096; len(report.PassBytes) != 2 || report.PassBytes[0] != want {
+		t.Errorf("PassBytes = %v, want a first pass of %d bytes", report.PassBytes, want)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("audit log full")
+}
+
+// The audit writer gets every byte of every pass, and failing to record them
+// fails the shred
+func TestAuditWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	size := int64(128 * 1024)
+	writeTestFile(t, path, size)
+
+	var audit bytes.Buffer
+	opts := DefaultOptions()
+	opts.PassPatterns = []PassPattern{PatternZero, PatternOne}
+	opts.AuditWriter = &audit
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	want := append(bytes.Repeat([]byte{0x00}, int(size)), bytes.Repeat([]byte{0xFF}, int(size))...)
+	if !bytes.Equal(audit.Bytes(), want) {
+		t.Errorf("audit got %d bytes, want %d zeros then %d 0xFF", audit.Len(), size, size)
+	}
+
+	writeTestFile(t, path, size)
+	opts.AuditWriter = failWriter{}
+	err = ShredWithOptions(path, opts)
+	if err == nil || !strings.Contains(err.Error(), "audit log full") {
+		t.Errorf("ShredWithOptions() error = %v, want the audit failure", err)
+	}
+}
+
+// The last pass runs on to the end of the file's last block
+func TestShredSlack(t *testing.T) {
+	dir := t.TempDir()
+	block, err := blockSize(dir)
+	if err != nil || block <= 1 {
+		t.Skipf("no usable block size: %d, %v", block, err)
+	}
+	path := filepath.Join(dir, "shredtest")
+	size := block + 1
+	writeTestFile(t, path, size)
+
+	report, err := ShredWithReport(path, 2)
+	if err != nil {
+		t.Fatalf("ShredWithReport() error = %v", err)
+	}
+	if len(report.PassBytes) != 2 || report.PassBytes[0] != size || report.PassBytes[1] != 2*block {
+		t.Errorf("PassBytes = %v, want [%d %d]", report.PassBytes, size, 2*block)
+	}
+}
+
+// Direct I/O passes cover whole blocks and verify, and fall back to the page
+// cache where O_DIRECT can't be used
+func TestDirectIO(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	size := int64(100*1024 + 1)
+	writeTestFile(t, path, size)
+
+	var logs bytes.Buffer
+	opts := DefaultOptions()
+	opts.Passes = 2
+	opts.DirectIO = true
+	opts.Verify = true
+	opts.BufferSize = 10000
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists: %v", err)
+	}
+
+	if strings.Contains(logs.String(), "direct I/O unavailable") {
+		t.Skipf("no direct I/O here: %s", logs.String())
+	}
+	block, _ := blockSize(dir)
+	want := (size + block - 1) / block * block
+	if len(report.PassBytes) != 2 || report.PassBytes[0] != want || report.PassBytes[1] != want {
+		t.Errorf("PassBytes = %v, want two passes of %d bytes", report.PassBytes, want)
+	}
+}
+
+// The pattern file is repeated over the file, and a missing or empty one
+// is refused before the file is touched
+func TestPatternFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	size := int64(1000)
+	writeTestFile(t, path, size)
+	patternPath := filepath.Join(dir, "pattern")
+	err := os.WriteFile(patternPath, []byte("abc"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var audit bytes.Buffer
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.PatternFile = patternPath
+	opts.AuditWriter = &audit
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	want := bytes.Repeat([]byte("abc"), int(size/3)+1)[:size]
+	if audit.Len() < int(size) || !bytes.Equal(audit.Bytes()[:size], want) {
+		t.Errorf("pass wrote %.20q..., want the pattern repeated", audit.Bytes())
+	}
+
+	os.WriteFile(patternPath, nil, 0600)
+	for _, pattern := range []string{patternPath, filepath.Join(dir, "missing")} {
+		writeTestFile(t, path, size)
+		opts.PatternFile = pattern
+		err = ShredWithOptions(path, opts)
+		if err == nil {
+			t.Errorf("ShredWithOptions() with pattern file %s succeeded", pattern)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != size {
+			t.Errorf("file was touched: %v", err)
+		}
+	}
+}
+
+// A kept file is overwritten in place and left as zeros of the same size,
+// with nothing else in its directory
+func TestKeepFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	size := int64(10000)
+	writeTestFile(t, path, size)
+
+	opts := DefaultOptions()
+	opts.Passes = 2
+	opts.KeepFile = true
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if report.PassesCompleted != 2 || report.Renames != 0 || report.FinalPath != path {
+		t.Errorf("report = %+v, want 2 passes, no renames, final path %s", report, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read kept file: %v", err)
+	}
+	if !bytes.Equal(data, make([]byte, size)) {
+		t.Errorf("kept file is %d bytes and not all zeros, want %d zeros", len(data), size)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d entries in the directory, want only the kept file", len(entries))
+	}
+}
+
+// With a WorkDir, the file's directory only ever sees the file go away, and
+// an interrupted shred resumes from the metadata kept there
+func TestWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	work := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 4096)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := DefaultOptions()
+	opts.Passes = 2
+	opts.WorkDir = work
+	opts.Progress = func(pass int64, written, total int64) {
+		if pass == 2 {
+			cancel()
+		}
+	}
+	opts = opts.withDefaults()
+	_, err := shred(ctx, path, opts.plan(), opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("shred() error = %v, want context.Canceled", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d entries left next to the file, want none", len(entries))
+	}
+	if entries, _ := os.ReadDir(work); len(entries) != 2 {
+		t.Errorf("%d entries in WorkDir, want the file and its metadata", len(entries))
+	}
+
+	opts.Progress = nil
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if !report.Resumed || filepath.Dir(report.FinalPath) != work {
+		t.Errorf("report = %+v, want a resumed shred ending in %s", report, work)
+	}
+	if entries, _ := os.ReadDir(work); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir, want none", len(entries))
+	}
+}
+
+// A WorkDir on another filesystem falls back to renaming in the file's own
+// directory instead of failing
+func TestWorkDirCrossDevice(t *testing.T) {
+	dir := t.TempDir()
+	work := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 4096)
+
+	fsys := &testFS{otherFS: work}
+	opts := DefaultOptions()
+	opts.WorkDir = work
+	opts.FS = fsys
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if filepath.Dir(report.FinalPath) != dir || report.Renames != opts.RenamePasses {
+		t.Errorf("report = %+v, want %d renames ending in %s", report, opts.RenamePasses, dir)
+	}
+	for _, d := range []string{dir, work} {
+		if entries, _ := os.ReadDir(d); len(entries) != 0 {
+			t.Errorf("%d entries left in %s, want none", len(entries), d)
+		}
+	}
+}
+
+func TestParsePassSpec(t *testing.T) {
+	patterns, err := ParsePassSpec("rzrzo")
+	want := []PassPattern{PatternRandom, PatternZero, PatternRandom, PatternZero, PatternOne}
+	if err != nil || !slices.Equal(patterns, want) {
+		t.Errorf("ParsePassSpec(\"rzrzo\") = %v, %v, want %v", patterns, err, want)
+	}
+
+	for _, spec := range []string{"", "rxz", "R"} {
+		if _, err := ParsePassSpec(spec); err == nil {
+			t.Errorf("ParsePassSpec(%q) accepted an invalid spec", spec)
+		}
+	}
+
+	// An invalid spec is refused before the file is touched
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+	opts := DefaultOptions()
+	opts.PassSpec = "rq"
+	err = ShredWithOptions(path, opts)
+	if err == nil || !strings.Contains(err.Error(), "unknown pass 'q'") {
+		t.Errorf("ShredWithOptions() error = %v, want the unknown pass", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("File was touched: %v", err)
+	}
+}
+
+// Zero rename passes leaves only the move to the temporary name
+func TestZeroRenamePasses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 128)
+
+	fsys := &testFS{}
+	opts := DefaultOptions()
+	opts.RenamePasses = 0
+	opts.FS = fsys
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	if report.Renames != 0 || fsys.renames != 1 {
+		t.Errorf("Renames = %d with %d calls to Rename, want 0 and 1", report.Renames, fsys.renames)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists: %v", err)
+	}
+}
+
+// Accepts n bytes, then fails every write
+type limitWriter struct{ n int }
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errors.New("audit log full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// The file is resized to a length from the range and overwritten once more
+// before removal, and a shred interrupted after the resize resumes
+func TestRandomSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	size := int64(4096)
+	writeTestFile(t, path, size)
+
+	var audit bytes.Buffer
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.RandomSizeMin = 10000
+	opts.RandomSizeMax = 10000
+	opts.AuditWriter = &audit
+	err := ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	if want := int(size + 10000); audit.Len() != want {
+		t.Errorf("wrote %d bytes, want %d for the pass and the resized file", audit.Len(), want)
+	}
+
+	// Fail the write after the resize, then resume at the new size
+	writeTestFile(t, path, size)
+	opts.AuditWriter = &limitWriter{n: int(size)}
+	err = ShredWithOptions(path, opts)
+	if err == nil {
+		t.Fatal("ShredWithOptions() succeeded with a failing audit writer")
+	}
+	opts.AuditWriter = nil
+	err = ShredWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("resumed ShredWithOptions() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d entries left behind", len(entries))
+	}
+
+	// A range that can't be drawn from is refused before the file is touched
+	writeTestFile(t, path, size)
+	opts.RandomSizeMin = 20000
+	err = ShredWithOptions(path, opts)
+	if err == nil {
+		t.Error("ShredWithOptions() accepted RandomSizeMin above RandomSizeMax")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("File was touched: %v", err)
+	}
+}
+
+// The command line resumes a shred interrupted after the rename, though the
+// path it is given no longer exists
+func TestShredPathResumes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shredtest")
+	writeTestFile(t, path, 4096)
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.AuditWriter = failWriter{}
+	err := shredPath(path, opts, false)
+	if err == nil {
+		t.Fatal("shredPath() succeeded with a failing audit writer")
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s still exists after the interrupted shred", path)
+	}
+
+	opts.AuditWriter = nil
+	err = shredPath(path, opts, false)
+	if err != nil {
+		t.Fatalf("resumed shredPath() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d entries left behind", len(entries))
+	}
+
+	// With nothing to resume, a missing path is still an error
+	err = shredPath(path, opts, false)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("shredPath() of a missing path error = %v, want ErrNotExist", err)
+	}
+}
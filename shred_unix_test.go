@@ -0,0 +1,89 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Create a named pipe, which blocks anyone opening it without a peer
+func makeFifo(t *testing.T, path string) {
+	t.Helper()
+	err := syscall.Mkfifo(path, 0600)
+	if err != nil {
+		t.Fatalf("Failed to create named pipe: %v", err)
+	}
+}
+
+// Fail the test if fn doesn't return within a few seconds
+func withinDeadline(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hung on a named pipe")
+	}
+}
+
+func TestShredFifo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	makeFifo(t, path)
+
+	var err error
+	withinDeadline(t, func() { err = Shred(path, 1) })
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Errorf("Shred() error = %v, want ErrUnsupportedFileType", err)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("Named pipe was removed: %s", path)
+	}
+}
+
+// The regular files are still shredded and the pipe is reported
+func TestShredDirFifo(t *testing.T) {
+	root := t.TempDir()
+	fifo := filepath.Join(root, "fifo")
+	makeFifo(t, fifo)
+	file := filepath.Join(root, "file")
+	writeTestFile(t, file, 128)
+
+	var err error
+	withinDeadline(t, func() { err = ShredDir(root, 1) })
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Errorf("ShredDir() error = %v, want ErrUnsupportedFileType", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("File still exists after shred: %s", file)
+	}
+}
+
+// A full filesystem gets one retry, then ErrNoSpace; /dev/full stands in
+// for a small tmpfs, which would need privileges to mount
+func TestWritePassNoSpace(t *testing.T) {
+	file, err := os.OpenFile("/dev/full", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("no /dev/full: %v", err)
+	}
+	defer file.Close()
+
+	writer := testPassWriter(t, file, ShredOptions{}.withDefaults())
+	start := time.Now()
+	_, err = writer.writePass(context.Background(), wholeFile(4096), PatternZero.pass())
+	if !errors.Is(err, ErrNoSpace) || !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("writePass() error = %v, want ErrNoSpace", err)
+	}
+	if elapsed := time.Since(start); elapsed < noSpaceRetryDelay {
+		t.Errorf("gave up after %v without waiting to retry", elapsed)
+	}
+}
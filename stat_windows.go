@@ -0,0 +1,25 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Device numbers aren't exposed through os.FileInfo on Windows
+func deviceID(info os.FileInfo) uint64 {
+	return 0
+}
+
+// File indexes aren't exposed through os.FileInfo on Windows
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
+
+// Owners are ACLs on Windows, not numeric IDs
+func owner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// Link counts aren't exposed through os.FileInfo on Windows
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
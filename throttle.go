@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Limits writes to an average number of bytes per second
+type throttle struct {
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{rate: bytesPerSecond, start: time.Now()}
+}
+
+// Account for n bytes written, sleeping until the average rate is back
+// under the limit. A nil throttle never waits.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+
+	t.sent += int64(n)
+	due := t.start.Add(time.Duration(float64(t.sent) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// fallocate modes for deallocating a range while keeping the file size
+const (
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+// Deallocate the range of the file, letting the filesystem discard the
+// underlying blocks
+func punchHole(file File, offset, length int64) error {
+	fd, ok := descriptor(file)
+	if !ok {
+		return errors.New("discarding blocks needs a file of the operating system")
+	}
+	return syscall.Fallocate(int(fd), fallocPunchHole|fallocKeepSize, offset, length)
+}
+
+// Whether the device holding the file is a spinning disk, according to
+// /sys/dev/block/<major>:<minor>/queue/rotational
+func isRotational(info os.FileInfo) (bool, error) {
+	dev := deviceID(info)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	block := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+
+	// Partitions share the queue of their parent disk
+	data, err := os.ReadFile(block + "/queue/rotational")
+	if err != nil {
+		data, err = os.ReadFile(block + "/../queue/rotational")
+		if err != nil {
+			return false, err
+		}
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Allocated bytes of the file
+func allocated(t *testing.T, file *os.File) int64 {
+	t.Helper()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestPunchHole(t *testing.T) {
+	const size = 1024 * 1024
+	file, err := os.Create(filepath.Join(t.TempDir(), "trim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = file.Write(make([]byte, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated(t, file) < size {
+		t.Skip("the filesystem didn't allocate the written blocks")
+	}
+
+	err = punchHole(file, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Skipf("no hole punching here: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("punchHole() error = %v", err)
+	}
+
+	// The blocks are gone but the size is kept
+	if n := allocated(t, file); n != 0 {
+		t.Errorf("after punchHole() %d bytes are still allocated", n)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("after punchHole() size = %d, want %d", info.Size(), size)
+	}
+}
+
+func TestShredSSDTrim(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trim")
+	err := os.WriteFile(path, make([]byte, 64*1024), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Passes = 1
+	opts.SSDTrim = true
+	err = ShredWithOptions(path, opts)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		t.Skipf("no hole punching here: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("ShredWithOptions() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("directory not empty after shred: %v", entries)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func punchHole(file File, offset, length int64) error {
+	return errors.New("discarding file blocks is not supported on this platform")
+}
+
+// Without a way to ask, assume a spinning disk so no advice is given
+func isRotational(info os.FileInfo) (bool, error) {
+	return true, nil
+}
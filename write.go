@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mrand "math/rand/v2"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How long to wait before writing again when the filesystem is full
+const noSpaceRetryDelay = 100 * time.Millisecond
+
+// A single overwrite pass: a fixed byte pattern, or random data if Pattern is nil
+type pass struct {
+	Pattern []byte
+	Verify  bool
+}
+
+// Build a plan of n random passes
+func randomPasses(n int64) []pass {
+	if n < 0 {
+		n = 0
+	}
+	return make([]pass, n)
+}
+
+// A byte range of a file
+type region struct {
+	Offset int64
+	Length int64
+}
+
+// A single region covering size bytes from the start of the file
+func wholeFile(size int64) []region {
+	return []region{{Offset: 0, Length: size}}
+}
+
+// Extend the regions out to multiples of align, merging any that then
+// overlap
+func alignRegions(regions []region, align int64) []region {
+	var aligned []region
+	for _, r := range regions {
+		start := r.Offset - r.Offset%align
+		end := r.Offset + r.Length
+		if rem := end % align; rem != 0 {
+			end += align - rem
+		}
+
+		if n := len(aligned); n > 0 && start <= aligned[n-1].Offset+aligned[n-1].Length {
+			aligned[n-1].Length = max(aligned[n-1].Length, end-aligned[n-1].Offset)
+			continue
+		}
+		aligned = append(aligned, region{Offset: start, Length: end - start})
+	}
+	return aligned
+}
+
+// A buffer of size bytes starting at a multiple of align in memory, as
+// direct I/O needs
+func alignedBuffer(size, align int) []byte {
+	if align <= 1 {
+		return make([]byte, size)
+	}
+	buf := make([]byte, size+align)
+	skip := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % uintptr(align)); rem != 0 {
+		skip = align - rem
+	}
+	return buf[skip : skip+size : skip+size]
+}
+
+// Fill buf with pattern, aligned so the pattern continues across chunks
+func fillPattern(buf []byte, pattern []byte, offset int64) {
+	start := int(offset % int64(len(pattern)))
+	for i := range buf {
+		buf[i] = pattern[(start+i)%len(pattern)]
+	}
+}
+
+// Writes overwrite passes to a file, one buffer at a time
+type passWriter struct {
+	file     File
+	buf      []byte
+	progress func(int64)  // Called with the bytes written so far in the pass, may be nil
+	throttle *throttle    // Caps the write rate, may be nil
+	random   io.Reader    // Source of random passes, crypto/rand if nil
+	audit    io.Writer    // Receives a copy of every byte written, may be nil
+	align    int          // Alignment of buffers in memory, for direct I/O
+	logger   *slog.Logger // Receives diagnostics, may be nil
+}
+
+// Writer for the file with the buffer and rate limit set in opts
+func newPassWriter(file File, opts ShredOptions) (*passWriter, error) {
+	writer := &passWriter{file: file, buf: make([]byte, opts.BufferSize)}
+	if opts.BytesPerSecond > 0 {
+		writer.throttle = newThrottle(opts.BytesPerSecond)
+	}
+	writer.audit = opts.AuditWriter
+	writer.logger = opts.logger()
+	writer.random = opts.randSource()
+	if opts.FastRandom {
+		random, err := newFastRandom(writer.random)
+		if err != nil {
+			return nil, err
+		}
+		writer.random = random
+	}
+	return writer, nil
+}
+
+// Switch the writer to a handle on the file at path opened for direct I/O,
+// with its buffer a multiple of align bytes and aligned in memory. The
+// regions written must then be aligned too. Returns the handle for the
+// caller to close.
+func (w *passWriter) directIO(path string, align int64) (*os.File, error) {
+	if align <= 0 {
+		return nil, errors.New("unknown block size")
+	}
+	file, err := openDirect(path)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (int64(len(w.buf)) + align - 1) / align * align
+	w.file = file
+	w.align = int(align)
+	w.buf = alignedBuffer(int(size), w.align)
+	return file, nil
+}
+
+// Write the rest of chunk once more after the first n bytes failed with
+// ENOSPC. Overwriting allocated blocks takes no space, but filling holes
+// and the slack, or any write on a copy-on-write filesystem, does, and some
+// may have been freed in the meantime. Returns ErrNoSpace if there still is
+// none.
+func (w *passWriter) retryNoSpace(ctx context.Context, chunk []byte, offset int64, n int) (int, error) {
+	if w.logger != nil {
+		w.logger.Warn("no space left on device, retrying", "path", w.file.Name(), "offset", offset+int64(n))
+	}
+	timer := time.NewTimer(noSpaceRetryDelay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		return n, ctx.Err()
+	}
+
+	m, err := w.file.WriteAt(chunk[n:], offset+int64(n))
+	n += m
+	if errors.Is(err, syscall.ENOSPC) {
+		return n, fmt.Errorf("%w: %s at offset %d: %w", ErrNoSpace, w.file.Name(), offset+int64(n), err)
+	}
+	return n, err
+}
+
+// A ChaCha8 keystream seeded from the random source. Much faster than
+// reading crypto/rand for every buffer, and still unpredictable without
+// the seed.
+func newFastRandom(random io.Reader) (io.Reader, error) {
+	// A short or failed read would leave a predictable, mostly zero key
+	var seed [32]byte
+	_, err := io.ReadFull(random, seed[:])
+	if err != nil {
+		return nil, fmt.Errorf("seed fast random: %w", err)
+	}
+	return mrand.NewChaCha8(seed), nil
+}
+
+// Run the passes of plan from index first on, recording them in report.
+// Every pass is synced to disk, unless opts.SkipSync is set, before done,
+// if set, is called with the number of passes completed.
+func runPasses(ctx context.Context, writer *passWriter, regions []region, plan []pass, first int64, opts ShredOptions, report *ShredReport, done func(int64) error) error {
+	var total int64
+	for _, r := range regions {
+		total += r.Length
+	}
+
+	for i := first; i < int64(len(plan)); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if opts.Progress != nil {
+			passNum := i + 1
+			writer.progress = func(written int64) { opts.Progress(passNum, written, total) }
+			writer.progress(0)
+		}
+
+		written, err := writer.writePass(ctx, regions, plan[i])
+		report.PassBytes = append(report.PassBytes, written)
+		report.TotalBytes += written
+		if err != nil {
+			return err
+		}
+
+		// Flush the pass to disk before recording it as done
+		if !opts.SkipSync {
+			err = writer.file.Sync()
+			if err != nil {
+				return err
+			}
+		}
+
+		if done != nil {
+			err = done(i + 1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Overwrite the regions of the file. Returns the number of bytes written,
+// even on failure.
+func (w *passWriter) writePass(ctx context.Context, regions []region, p pass) (int64, error) {
+	var written int64
+	for _, r := range regions {
+		n, err := w.writeRegion(ctx, r, p, written)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Overwrite a single region of the file, after done bytes of the pass
+// were already written
+func (w *passWriter) writeRegion(ctx context.Context, r region, p pass, done int64) (int64, error) {
+	var check []byte
+	if p.Verify {
+		check = alignedBuffer(len(w.buf), w.align)
+	}
+
+	end := r.Offset + r.Length
+	for offset := r.Offset; offset < end; {
+		if err := ctx.Err(); err != nil {
+			return offset - r.Offset, err
+		}
+
+		chunk := w.buf
+		if remaining := end - offset; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		if p.Pattern == nil {
+			// Refill for every chunk so no two blocks are identical
+			random := w.random
+			if random == nil {
+				random = rand.Reader
+			}
+			_, err := io.ReadFull(random, chunk)
+			if err != nil {
+				return offset - r.Offset, err
+			}
+		} else {
+			fillPattern(chunk, p.Pattern, offset)
+		}
+
+		n, err := w.file.WriteAt(chunk, offset)
+		if errors.Is(err, syscall.ENOSPC) {
+			n, err = w.retryNoSpace(ctx, chunk, offset, n)
+		}
+		// Only the bytes that reached the file go to the audit stream
+		if w.audit != nil && n > 0 {
+			_, auditErr := w.audit.Write(chunk[:n])
+			if auditErr != nil && err == nil {
+				err = fmt.Errorf("audit: %w", auditErr)
+			}
+		}
+		if err != nil {
+			return offset - r.Offset + int64(n), err
+		}
+
+		// Read the chunk back and compare it to what was written
+		if p.Verify {
+			readBack := check[:n]
+			_, err = w.file.ReadAt(readBack, offset)
+			if err != nil {
+				return offset - r.Offset, err
+			}
+			for i := range readBack {
+				if readBack[i] != chunk[i] {
+					return offset - r.Offset, fmt.Errorf("verification failed at offset %d: wrote 0x%02x, read back 0x%02x", offset+int64(i), chunk[i], readBack[i])
+				}
+			}
+		}
+		offset += int64(n)
+
+		if w.progress != nil {
+			w.progress(done + offset - r.Offset)
+		}
+
+		err = w.throttle.wait(ctx, n)
+		if err != nil {
+			return offset - r.Offset, err
+		}
+	}
+
+	return r.Length, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// A pass writer for the file, failing the test if it can't be made
+func testPassWriter(tb testing.TB, file *os.File, opts ShredOptions) *passWriter {
+	tb.Helper()
+	writer, err := newPassWriter(file, opts)
+	if err != nil {
+		tb.Fatalf("newPassWriter() error = %v", err)
+	}
+	return writer
+}
+
+// A pass streams through its buffer, so memory use doesn't grow with the
+// file size
+func TestWritePassMemory(t *testing.T) {
+	size := int64(32 * 1024 * 1024)
+	file, err := os.Create(filepath.Join(t.TempDir(), "shredtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := testPassWriter(t, file, ShredOptions{}.withDefaults())
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = writer.writePass(context.Background(), wholeFile(size), pass{Verify: true})
+	if err != nil {
+		t.Fatalf("writePass() error = %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(size/8) {
+		t.Errorf("pass over %d bytes allocated %d bytes", size, allocated)
+	}
+}
+
+// Random passes fill every block with fresh data instead of repeating one
+// buffer across the file
+func TestWritePassBlocksDiffer(t *testing.T) {
+	for _, fast := range []bool{false, true} {
+		file, err := os.Create(filepath.Join(t.TempDir(), "shredtest"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		opts := ShredOptions{FastRandom: fast}.withDefaults()
+		size := int64(16 * opts.BufferSize)
+		_, err = testPassWriter(t, file, opts).writePass(context.Background(), wholeFile(size), pass{})
+		if err != nil {
+			t.Fatalf("writePass() error = %v", err)
+		}
+
+		data, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[[sha256.Size]byte]int64{}
+		for offset := int64(0); offset < size; offset += int64(opts.BufferSize) {
+			sum := sha256.Sum256(data[offset : offset+int64(opts.BufferSize)])
+			if previous, ok := seen[sum]; ok {
+				t.Errorf("FastRandom=%v: block at %d repeats block at %d", fast, offset, previous)
+			}
+			seen[sum] = offset
+		}
+	}
+}
+
+func TestAlignRegions(t *testing.T) {
+	tests := []struct {
+		regions []region
+		want    []region
+	}{
+		{wholeFile(5000), []region{{0, 8192}}},
+		{wholeFile(8192), []region{{0, 8192}}},
+		{[]region{{100, 10}, {9000, 100}}, []region{{0, 4096}, {8192, 4096}}},
+		{[]region{{100, 10}, {5000, 100}}, []region{{0, 8192}}},
+		{[]region{{0, 4000}, {4050, 100}}, []region{{0, 8192}}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		got := alignRegions(tt.regions, 4096)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("alignRegions(%v) = %v, want %v", tt.regions, got, tt.want)
+		}
+	}
+}
+
+// Time one random pass over a 64MB file with the given writer settings
+func benchmarkRandomPass(b *testing.B, opts ShredOptions) {
+	size := int64(64 * 1024 * 1024)
+	file, err := os.Create(filepath.Join(b.TempDir(), "bench"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := testPassWriter(b, file, opts.withDefaults())
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = writer.writePass(context.Background(), wholeFile(size), pass{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRandomPassCryptoRand(b *testing.B) {
+	benchmarkRandomPass(b, ShredOptions{})
+}
+
+func BenchmarkRandomPassFastRandom(b *testing.B) {
+	benchmarkRandomPass(b, ShredOptions{FastRandom: true})
+}
+
+// A random source that can't seed FastRandom fails instead of leaving a
+// predictable key
+func TestFastRandomSeedFailure(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "shredtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	opts := ShredOptions{FastRandom: true, RandSource: io.LimitReader(rand.Reader, 16)}.withDefaults()
+	_, err = newPassWriter(file, opts)
+	if err == nil || !strings.Contains(err.Error(), "seed fast random") {
+		t.Errorf("newPassWriter() error = %v, want the seed failure", err)
+	}
+}
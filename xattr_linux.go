@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// Names of the extended attributes of the file at path
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		size, err = syscall.Listxattr(path, buf)
+		// Another attribute was added since the size was asked for
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSuffix(string(buf[:size]), "\x00"), "\x00"), nil
+	}
+}
+
+func removeXattr(path, name string) error {
+	return syscall.Removexattr(path, name)
+}
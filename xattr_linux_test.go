@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// The attributes are reported and gone afterwards, which shows on a kept
+// file
+func TestWipeXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shredtest")
+	writeTestFile(t, path, 4096)
+	for _, name := range []string{"user.label", "user.secret"} {
+		err := syscall.Setxattr(path, name, []byte("classified"), 0)
+		if err != nil {
+			t.Skipf("no user extended attributes here: %v", err)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.WipeXattrs = true
+	opts.KeepFile = true
+	opts = opts.withDefaults()
+	report, err := shred(context.Background(), path, opts.plan(), opts)
+	if err != nil {
+		t.Fatalf("shred() error = %v", err)
+	}
+	slices.Sort(report.Xattrs)
+	if !slices.Equal(report.Xattrs, []string{"user.label", "user.secret"}) {
+		t.Errorf("Xattrs = %v, want user.label and user.secret", report.Xattrs)
+	}
+	if left, err := listXattrs(path); err != nil || len(left) != 0 {
+		t.Errorf("attributes left on the file: %v, %v", left, err)
+	}
+}
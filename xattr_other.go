@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func listXattrs(path string) ([]string, error) {
+	return nil, errors.New("extended attributes are not supported on this platform")
+}
+
+func removeXattr(path, name string) error {
+	return errors.New("extended attributes are not supported on this platform")
+}